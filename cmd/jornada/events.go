@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/brunoluiz/jornada/internal/op/logger"
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/storage/badgerdb"
+	"github.com/brunoluiz/jornada/internal/storage/zstdict"
+	"github.com/urfave/cli/v2"
+)
+
+var eventsCmd = &cli.Command{
+	Name:  "events",
+	Usage: "Manage stored session events (uses --events-dsn, BadgerDB only). The service must be stopped, as the storage can't be shared",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "compress",
+			Usage: "Compress events stored without compression or with an outdated dictionary",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "train", Usage: "Train a new dictionary from stored events before compressing them (always done if there is no dictionary yet)"},
+				&cli.IntFlag{Name: "samples", Value: 10000, Usage: "How many events are sampled to train the dictionary"},
+				&cli.IntFlag{Name: "dict-size", Value: zstdict.DefaultDictSize, Usage: "Maximum dictionary size, in bytes"},
+			},
+			Action: eventsCompress,
+		},
+		{
+			Name:   "index",
+			Usage:  "Index the timestamps and keyframes of events stored before they were indexed on write",
+			Action: eventsIndex,
+		},
+	},
+}
+
+func eventsCompress(c *cli.Context) error {
+	b, err := badgerdb.New(c.String("events-dsn"), logger.New(c.String("log-level")))
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	events, err := repo.NewEventBadger(b.BadgerDB)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("train") || !events.HasDictionary() {
+		id, err := events.TrainDictionary(c.Context, c.Int("samples"), c.Int("dict-size"))
+		switch {
+		case errors.Is(err, zstdict.ErrNotEnoughSamples) && !c.Bool("train"):
+			fmt.Println("not enough events to train a dictionary yet, compressing without one")
+		case err != nil:
+			return fmt.Errorf("train dictionary: %w", err)
+		default:
+			fmt.Printf("trained dictionary %d\n", id)
+		}
+	}
+
+	stats, err := events.Compress(c.Context)
+	if err != nil {
+		return err
+	}
+
+	savings := 0.0
+	if stats.RawBytes > 0 {
+		savings = 100 * (1 - float64(stats.BytesAfter)/float64(stats.RawBytes))
+	}
+	fmt.Printf("compressed %d events: %d bytes before, %d after (%.1f%% smaller than raw)\n", stats.Events, stats.BytesBefore, stats.BytesAfter, savings)
+	return nil
+}
+
+func eventsIndex(c *cli.Context) error {
+	b, err := badgerdb.New(c.String("events-dsn"), logger.New(c.String("log-level")))
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	events, err := repo.NewEventBadger(b.BadgerDB)
+	if err != nil {
+		return err
+	}
+
+	count, err := events.Index(c.Context)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("indexed %d events\n", count)
+	return nil
+}
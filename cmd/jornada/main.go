@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/brunoluiz/jornada/internal/cleaner"
 	"github.com/brunoluiz/jornada/internal/op/logger"
 	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/search/v2"
 	"github.com/brunoluiz/jornada/internal/server"
 	"github.com/brunoluiz/jornada/internal/storage/badgerdb"
+	"github.com/brunoluiz/jornada/internal/storage/filedb"
+	"github.com/brunoluiz/jornada/internal/storage/s3db"
 	"github.com/brunoluiz/jornada/internal/storage/sqldb"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
 )
@@ -26,12 +33,15 @@ func main() {
 			&cli.StringFlag{Name: "port", Value: "3000", EnvVars: []string{"PORT"}, Usage: "Service port for public service"},
 			&cli.StringFlag{Name: "admin-port", Value: "3001", EnvVars: []string{"ADMIN_PORT"}, Usage: "Service port for admin service"},
 			&cli.StringSliceFlag{Name: "allowed-origins", Value: cli.NewStringSlice("*"), EnvVars: []string{"ALLOWED_ORIGINS"}, Usage: "CORS allowed origins"},
-			&cli.StringFlag{Name: "db-dsn", Value: "sqlite:///tmp/jornada.db?cache=shared&mode=rwc&_journal_mode=WAL", EnvVars: []string{"DB_DSN"}, Usage: "DSN for SQL database (see github.com/mattn/go-sqlite3 for more options)"},
-			&cli.StringFlag{Name: "events-dsn", Value: "badger:///tmp/jornada.events", EnvVars: []string{"EVENTS_DSN"}, Usage: "Events storage path (BadgerDB)"},
+			&cli.StringFlag{Name: "db-dsn", Value: "sqlite:///tmp/jornada.db?cache=shared&mode=rwc&_journal_mode=WAL", EnvVars: []string{"DB_DSN"}, Usage: "DSN for SQL database, sqlite:// (see github.com/mattn/go-sqlite3 for more options), postgres:// (see github.com/lib/pq) or mysql:// (see github.com/go-sql-driver/mysql)"},
+			&cli.StringFlag{Name: "events-dsn", Value: "badger:///tmp/jornada.events", EnvVars: []string{"EVENTS_DSN"}, Usage: "DSN for events storage, badger:// (BadgerDB path), file:// (directory of append-only NDJSON files, compressed with ?compress=zstd) or s3://bucket/prefix (S3 compatible storage, see ?endpoint=, ?buffer= and ?idle=)"},
 			&cli.DurationFlag{Name: "storage-max-age", Value: time.Hour * 24 * 14, EnvVars: []string{"STORAGE_MAX_AGE"}, Usage: "How long should Jornada keep sessions stored in database (14 days by default)"},
 			&cli.StringFlag{Name: "log-level", Value: "info", EnvVars: []string{"LOG_LEVEL"}, Usage: "Log level"},
+			&cli.StringSliceFlag{Name: "promoted-meta-keys", EnvVars: []string{"PROMOTED_META_KEYS"}, Usage: "Meta keys to index, speeding up their searches (SQLite and PostgreSQL only). Keys compared to numbers or booleans must be suffixed with :number or :bool, such as plan,cart.total:number. Indexes are managed by migrations (see the migrate command)"},
+			&cli.BoolFlag{Name: "auto-migrate", EnvVars: []string{"AUTO_MIGRATE"}, Usage: "If set, pending database migrations are applied on start-up. Otherwise, the service refuses to start with an out of date schema (see the migrate command)"},
 		},
-		Action: run,
+		Commands: []*cli.Command{migrateCmd, eventsCmd},
+		Action:   run,
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -40,14 +50,14 @@ func main() {
 }
 
 func run(c *cli.Context) error {
-	ctx, _ := signal.NotifyContext(c.Context, os.Interrupt)
+	ctx, _ := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
 	log := logger.New(c.String("log-level"))
 
-	b, err := badgerdb.New(c.String("events-dsn"), log)
+	events, closeEvents, err := newEventStorage(ctx, c.String("events-dsn"), log)
 	if err != nil {
 		return err
 	}
-	defer b.Close()
+	defer closeEvents()
 
 	db, err := sqldb.New(c.String("db-dsn"))
 	if err != nil {
@@ -55,12 +65,43 @@ func run(c *cli.Context) error {
 	}
 	defer db.Close()
 
-	events := repo.NewEventBadger(b.BadgerDB)
-	recordings, err := repo.NewSessionSQL(ctx, db, log)
+	promotedKeys, err := search.ParsePromotedKeys(c.StringSlice("promoted-meta-keys"))
 	if err != nil {
 		return err
 	}
 
+	migrator := sqldb.NewMigrator(db, repo.Migrations)
+	promoter := repo.NewMetaKeyPromoter(db, promotedKeys)
+	if c.Bool("auto-migrate") {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			log.WithField("version", m.Version).Infof("migration %s applied", m.Name)
+		}
+
+		promoted, demoted, err := promoter.Up(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range promoted {
+			log.WithField("column", k.Column()).Infof("meta key %s promoted", k)
+		}
+		for _, k := range demoted {
+			log.WithField("column", k.Column()).Infof("meta key %s demoted", k)
+		}
+	} else {
+		if err := migrator.Check(ctx); err != nil {
+			return fmt.Errorf("%w: run `jornada migrate up` or start with --auto-migrate", err)
+		}
+		if err := promoter.Check(ctx); err != nil {
+			return fmt.Errorf("%w: run `jornada migrate up` or start with --auto-migrate", err)
+		}
+	}
+
+	recordings := repo.NewSessionSQL(db, log)
+
 	clean := cleaner.New(c.Duration("storage-max-age"), recordings, events)
 
 	publicSvc := server.NewPublic(
@@ -80,15 +121,71 @@ func run(c *cli.Context) error {
 		recordings,
 		events,
 		server.Config{
-			Addr:      c.String("address") + ":" + c.String("admin-port"),
-			PublicURL: c.String("public-url"),
+			Addr:             c.String("address") + ":" + c.String("admin-port"),
+			PublicURL:        c.String("public-url"),
+			SQLDialect:       db.Dialect,
+			PromotedMetaKeys: promotedKeys,
 		},
 	)
 	if err != nil {
 		return err
 	}
 
-	return waiter(ctx, clean.Run, publicSvc.Run, adminSvc.Run)
+	runners := []func(context.Context) error{clean.Run, publicSvc.Run, adminSvc.Run}
+	// storages which buffer events upload them in the background
+	if uploader, ok := events.(interface{ Run(context.Context) error }); ok {
+		runners = append(runners, uploader.Run)
+	}
+
+	return waiter(ctx, runners...)
+}
+
+// eventStorage defines the events storage used by the services and the cleaner
+type eventStorage interface {
+	server.EventRepository
+	cleaner.BulkDeleter
+}
+
+// newEventStorage opens the events storage defined by the DSN scheme, returning a function which closes it
+func newEventStorage(ctx context.Context, dsn string, log *logrus.Logger) (eventStorage, func() error, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch u.Scheme {
+	case "badger":
+		b, err := badgerdb.New(dsn, log)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		events, err := repo.NewEventBadger(b.BadgerDB)
+		if err != nil {
+			b.Close()
+			return nil, nil, err
+		}
+		return events, b.Close, nil
+	case "file":
+		f, err := filedb.New(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return repo.NewEventFile(f), func() error { return nil }, nil
+	case "s3":
+		s, err := s3db.New(ctx, dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		events, err := repo.NewEventS3(s, s.BufferPath, s.Idle, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		return events, func() error { return nil }, nil
+	}
+
+	return nil, nil, fmt.Errorf("events storage %s not supported", u.Scheme)
 }
 
 func waiter(ctx context.Context, runners ...func(context.Context) error) error {
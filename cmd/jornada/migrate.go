@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+	"github.com/urfave/cli/v2"
+)
+
+var migrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "Manage SQL database migrations (uses --db-dsn)",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "up",
+			Usage:  "Apply all pending migrations and promote (or demote) meta keys (see --promoted-meta-keys)",
+			Action: migrateUp,
+		},
+		{
+			Name:   "down",
+			Usage:  "Roll back the last applied migrations",
+			Flags:  []cli.Flag{&cli.IntFlag{Name: "steps", Value: 1, Usage: "How many migrations should be rolled back"}},
+			Action: migrateDown,
+		},
+		{
+			Name:   "status",
+			Usage:  "List migrations and if they were applied",
+			Action: migrateStatus,
+		},
+	},
+}
+
+func withMigrator(c *cli.Context, fn func(m *sqldb.Migrator, p *repo.MetaKeyPromoter) error) error {
+	keys, err := search.ParsePromotedKeys(c.StringSlice("promoted-meta-keys"))
+	if err != nil {
+		return err
+	}
+
+	db, err := sqldb.New(c.String("db-dsn"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return fn(sqldb.NewMigrator(db, repo.Migrations), repo.NewMetaKeyPromoter(db, keys))
+}
+
+func migrateUp(c *cli.Context) error {
+	return withMigrator(c, func(m *sqldb.Migrator, p *repo.MetaKeyPromoter) error {
+		applied, err := m.Up(c.Context)
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+
+		promoted, demoted, err := p.Up(c.Context)
+		for _, k := range promoted {
+			fmt.Printf("promoted meta key %s (%s)\n", k, k.Column())
+		}
+		for _, k := range demoted {
+			fmt.Printf("demoted meta key %s (%s)\n", k, k.Column())
+		}
+		return err
+	})
+}
+
+func migrateDown(c *cli.Context) error {
+	return withMigrator(c, func(m *sqldb.Migrator, _ *repo.MetaKeyPromoter) error {
+		rolledBack, err := m.Down(c.Context, c.Int("steps"))
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	})
+}
+
+func migrateStatus(c *cli.Context) error {
+	return withMigrator(c, func(m *sqldb.Migrator, p *repo.MetaKeyPromoter) error {
+		status, err := m.Status(c.Context)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		pending := false
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			} else {
+				pending = true
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+
+		// promoted keys are recorded by migrations, so they can only be checked once these are applied
+		if !pending {
+			promote, demote, err := p.Pending(c.Context)
+			if err != nil {
+				return err
+			}
+			if len(promote) > 0 || len(demote) > 0 {
+				fmt.Fprintln(w, "\nMETA KEY\tCOLUMN\tSTATUS")
+			}
+			for _, k := range promote {
+				fmt.Fprintf(w, "%s\t%s\tpending promotion\n", k, k.Column())
+			}
+			for _, k := range demote {
+				fmt.Fprintf(w, "%s\t%s\tpending demotion\n", k, k.Column())
+			}
+		}
+		return w.Flush()
+	})
+}
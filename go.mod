@@ -3,16 +3,20 @@ module github.com/brunoluiz/jornada
 go 1.16
 
 require (
-	github.com/DataDog/zstd v1.4.4 // indirect
+	github.com/DataDog/zstd v1.5.7
 	github.com/Masterminds/squirrel v1.5.0
 	github.com/dgraph-io/badger/v2 v2.2007.2
 	github.com/dgraph-io/ristretto v0.0.4-0.20210122082011-bb5d392ed82d // indirect
 	github.com/dgryski/go-farm v0.0.0-20200201041132-a6ae2369ad13 // indirect
 	github.com/go-chi/chi v4.1.1+incompatible
 	github.com/go-chi/cors v1.1.1
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/joho/godotenv v1.3.0
+	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/kr/pretty v0.2.0 // indirect
+	github.com/lib/pq v1.10.0
 	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/minio/minio-go/v7 v7.0.10
 	github.com/oklog/ulid v1.3.1
 	github.com/prometheus/client_golang v1.9.0
 	github.com/sirupsen/logrus v1.6.0
@@ -17,6 +17,7 @@ type BulkDeleter interface {
 type SessionRepository interface {
 	BulkDeleter
 	Get(ctx context.Context, opts ...repo.GetOpt) ([]repo.Session, error)
+	DeleteOrphanUsers(ctx context.Context) (int64, error)
 }
 
 // Cleaner finds old records using session repository and then deletes items older than StorageMaxAge
@@ -55,7 +56,7 @@ func (c *Cleaner) Run(ctx context.Context) error {
 func (c *Cleaner) run(ctx context.Context) error {
 	t := time.Now().Add(-c.StorageMaxAge)
 
-	sessions, err := c.Sessions.Get(ctx, repo.WithUpdatedAtUntil(t))
+	sessions, err := c.Sessions.Get(ctx, repo.WithInactiveSince(t))
 	if err != nil {
 		return err
 	}
@@ -70,5 +71,18 @@ func (c *Cleaner) run(ctx context.Context) error {
 		return err
 	}
 
-	return c.Sessions.Delete(ctx, ids...)
+	if err := c.Sessions.Delete(ctx, ids...); err != nil {
+		return err
+	}
+
+	// users are shared by sessions, so they are only deleted once all their sessions are gone
+	users, err := c.Sessions.DeleteOrphanUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		log.Printf("deleted %d orphan users", users)
+	}
+
+	return nil
 }
@@ -3,22 +3,64 @@ package repo
 import (
 	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
+	"math/rand"
 
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/brunoluiz/jornada/internal/storage/zstdict"
 	"github.com/dgraph-io/badger/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Formats of stored events, which are set as the user meta of their keys. Events stored before
+// compression was introduced are raw JSON.
+const (
+	eventFormatRaw  byte = 0
+	eventFormatZstd byte = 1
+)
+
+// Keys of the zstd dictionaries used to compress events: zstd/dicts/{dict_id} and the ID of the
+// dictionary used for new events
+const (
+	dictPrefix     = "zstd/dicts/"
+	currentDictKey = "zstd/current"
+)
+
+// maxSampleSize limits the size of the events used to train dictionaries, as full snapshots can be
+// large, while dictionaries are most useful for the common (and smaller) incremental events
+const maxSampleSize = 64 * 1024
+
+var eventBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "jornada_events_bytes_total",
+	Help: "Size of added events before (raw) and after (stored) compression: storage savings are 1 - stored / raw",
+}, []string{"size"})
+
 // EventBadgerV2 defines an event storage using badger v2
 // This storage is using the following format: events/{session_id}/{event_sequential_id}
 // Each new event sent by the recording library is going to have a sequential ID, making it
-// easy to seek afterwards
+// easy to seek afterwards. Events are indexed by their timestamps, with full snapshots indexed
+// as keyframes as well, so replays can start at any time (see KeyframeAt). Events are
+// compressed with zstd, using the last trained dictionary (see TrainDictionary).
 type EventBadgerV2 struct {
-	db *badger.DB
+	db    *badger.DB
+	codec *zstdict.Codec
+}
+
+// CompressStats are the totals of events compressed by EventBadgerV2.Compress
+type CompressStats struct {
+	Events      uint64
+	RawBytes    uint64
+	BytesBefore uint64
+	BytesAfter  uint64
 }
 
-// NewEventBadger returns a new *EventBadgerV2
-func NewEventBadger(db *badger.DB) *EventBadgerV2 {
-	return &EventBadgerV2{db}
+// NewEventBadger returns a new *EventBadgerV2, loading the compression dictionaries
+func NewEventBadger(db *badger.DB) (*EventBadgerV2, error) {
+	store := &EventBadgerV2{db: db, codec: zstdict.NewCodec(3)}
+	return store, store.loadDicts()
 }
 
 // Add bulk adds events for a certain session id -- key value will be suffixed with sequential ID
@@ -34,6 +76,9 @@ func (store *EventBadgerV2) Add(ctx context.Context, sessionID string, msgs ...[
 			if err := store.writeMsg(tx, sessionID, seq, msg); err != nil {
 				return err
 			}
+			if err := store.indexMsg(tx, sessionID, seq, msg); err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -42,7 +87,33 @@ func (store *EventBadgerV2) Add(ctx context.Context, sessionID string, msgs ...[
 
 // Get all events for a certain session id
 func (store *EventBadgerV2) Get(ctx context.Context, sessionID string, cb func(b []byte, pos, size uint64) error) error {
-	return store.db.View(func(tx *badger.Txn) error {
+	_, err := store.GetRange(ctx, sessionID, EventRange{}, cb)
+	return err
+}
+
+// GetRange get events for a certain session id within a range, in order. Positions and size passed to
+// cb are relative to the first returned event. If there are more events in the range than its limit,
+// a cursor to the next page is returned.
+func (store *EventBadgerV2) GetRange(ctx context.Context, sessionID string, rng EventRange, cb func(b []byte, pos, size uint64) error) (next string, err error) {
+	rng, err = rng.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	err = store.db.View(func(tx *badger.Txn) error {
+		lastID, ok := store.seekLastSequence(tx, sessionID)
+		if !ok {
+			return nil
+		}
+
+		var from, size uint64
+		from, size, next, err = rng.bounds(lastID, func(ts int64, after bool) (uint64, bool, error) {
+			return store.seqAt(tx, sessionID, ts, after)
+		})
+		if err != nil || size == 0 {
+			return err
+		}
+
 		it := tx.NewIterator(badger.IteratorOptions{
 			PrefetchValues: true,
 			PrefetchSize:   100,
@@ -51,15 +122,15 @@ func (store *EventBadgerV2) Get(ctx context.Context, sessionID string, cb func(b
 		})
 		defer it.Close()
 
-		lastID, err := store.lastSequence(tx, sessionID)
-		if err != nil {
-			return err
-		}
-
 		var count uint64
-		for it.Seek(store.messageKey(sessionID, 1)); it.ValidForPrefix([]byte(store.id(sessionID))); it.Next() {
-			if err := it.Item().Value(func(msg []byte) error {
-				if err := cb(msg, count, lastID); err != nil {
+		for it.Seek(store.messageKey(sessionID, from)); it.ValidForPrefix([]byte(store.id(sessionID))) && count < size; it.Next() {
+			item := it.Item()
+			if err := item.Value(func(b []byte) error {
+				msg, err := store.decode(item.UserMeta(), b)
+				if err != nil {
+					return err
+				}
+				if err := cb(msg, count, size); err != nil {
 					return err
 				}
 				count++
@@ -70,6 +141,111 @@ func (store *EventBadgerV2) Get(ctx context.Context, sessionID string, cb func(b
 		}
 		return nil
 	})
+	if err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// Keyframe is the position of a full snapshot, from which sessions can be replayed. Timestamp is the
+// full snapshot rrweb timestamp, while Seq might point to the meta event which precedes it.
+type Keyframe struct {
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// KeyframeAt returns the last keyframe recorded at or before an rrweb timestamp (unix milliseconds). As
+// the player requires the viewport size, the keyframe starts at the meta event which precedes the full
+// snapshot, if there is one. If there is no keyframe (or events weren't indexed), false is returned.
+func (store *EventBadgerV2) KeyframeAt(ctx context.Context, sessionID string, ts int64) (kf Keyframe, ok bool, err error) {
+	err = store.db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.IteratorOptions{Reverse: true})
+		defer it.Close()
+
+		prefix := store.keyframesPrefix(sessionID)
+		if it.Seek(store.indexKey(prefix, ts, math.MaxUint64)); !it.ValidForPrefix([]byte(prefix)) {
+			return nil
+		}
+		kf.Timestamp, kf.Seq = store.parseIndexKey(prefix, it.Item().Key())
+		ok = true
+
+		if kf.Seq <= 1 {
+			return nil
+		}
+		item, err := tx.Get(store.messageKey(sessionID, kf.Seq-1))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(b []byte) error {
+			msg, err := store.decode(item.UserMeta(), b)
+			if err != nil {
+				return err
+			}
+			if events, err := rrweb.Parse(msg); err == nil && events[0].Type == rrweb.EventMeta {
+				kf.Seq--
+			}
+			return nil
+		})
+	})
+	return kf, ok, err
+}
+
+// SeqAt returns the sequence of the last event recorded at or before an rrweb timestamp (unix
+// milliseconds). If there is none, false is returned.
+func (store *EventBadgerV2) SeqAt(ctx context.Context, sessionID string, ts int64) (seq uint64, ok bool, err error) {
+	err = store.db.View(func(tx *badger.Txn) (err error) {
+		seq, ok, err = store.seqAt(tx, sessionID, ts, false)
+		return err
+	})
+	return seq, ok, err
+}
+
+// Index indexes the timestamps and keyframes of all stored events, such as the ones stored before
+// events were indexed, returning how many were indexed
+func (store *EventBadgerV2) Index(ctx context.Context) (count uint64, err error) {
+	type event struct {
+		id  string
+		seq uint64
+		msg []byte
+	}
+
+	batch := make([]event, 0, 1000)
+	flush := func() error {
+		err := store.db.Update(func(tx *badger.Txn) error {
+			for _, e := range batch {
+				if err := store.indexMsg(tx, e.id, e.seq, e.msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		count += uint64(len(batch))
+		batch = batch[:0]
+		return err
+	}
+
+	err = store.eachEvent(ctx, func(item *badger.Item, b []byte) error {
+		msg, err := store.decode(item.UserMeta(), b)
+		if err != nil {
+			return err
+		}
+
+		key := item.Key()
+		id := string(key[len("events/") : len(key)-9])
+		batch = append(batch, event{id, binary.BigEndian.Uint64(key[len(key)-8:]), append([]byte{}, msg...)})
+		if len(batch) == cap(batch) {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, flush()
 }
 
 // Delete delete a specified set of IDs
@@ -85,18 +261,18 @@ func (store *EventBadgerV2) Delete(ctx context.Context, ids ...string) error {
 		keysForDelete := make([][]byte, 0, collectSize)
 		keysCollected := 0
 		for _, id := range ids {
-			sessionID := id
-
-			for it.Seek(store.messageKey(sessionID, 1)); it.ValidForPrefix([]byte(store.id(sessionID))); it.Next() {
-				key := it.Item().KeyCopy(nil)
-				keysForDelete = append(keysForDelete, key)
-				keysCollected++
-				if keysCollected == collectSize {
-					if err := store.deleteKeys(keysForDelete); err != nil {
-						return err
+			for _, prefix := range []string{store.id(id), store.timestampsPrefix(id), store.keyframesPrefix(id)} {
+				for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+					key := it.Item().KeyCopy(nil)
+					keysForDelete = append(keysForDelete, key)
+					keysCollected++
+					if keysCollected == collectSize {
+						if err := store.deleteKeys(keysForDelete); err != nil {
+							return err
+						}
+						keysForDelete = make([][]byte, 0, collectSize)
+						keysCollected = 0
 					}
-					keysForDelete = make([][]byte, 0, collectSize)
-					keysCollected = 0
 				}
 			}
 		}
@@ -124,26 +300,182 @@ func (store *EventBadgerV2) deleteKeys(keysForDelete [][]byte) error {
 
 // lastSequence gets last ID saved in DB
 func (store *EventBadgerV2) lastSequence(tx *badger.Txn, id string) (uint64, error) {
+	// if no data is available, initialise storage for this recording
+	seq, ok := store.seekLastSequence(tx, id)
+	if !ok {
+		if err := tx.Set(store.messageKey(id, 0), []byte{}); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// seekLastSequence gets last ID saved in DB, without initialising the storage of a recording
+func (store *EventBadgerV2) seekLastSequence(tx *badger.Txn, id string) (uint64, bool) {
 	it := tx.NewIterator(badger.IteratorOptions{
 		PrefetchValues: false,
 		Reverse:        true,
 	})
 	defer it.Close()
 
-	// if no data is available, initialise storage for this recording
 	if it.Seek(store.messageKey(id, math.MaxUint64)); !it.ValidForPrefix([]byte(store.id(id))) {
-		if err := store.writeMsg(tx, id, 0, []byte{}); err != nil {
-			return 0, err
-		}
-		return store.lastSequence(tx, id)
+		return 0, false
 	}
 
 	lastKey := it.Item().Key()
-	return binary.BigEndian.Uint64(lastKey[len(store.id(id)):]), nil
+	return binary.BigEndian.Uint64(lastKey[len(store.id(id)):]), true
+}
+
+// seqAt seeks the timestamps index for the sequence of the last event recorded at or before ts, or
+// the first event recorded at or after it if after is set. Sessions stored before events were indexed
+// (see Index) are scanned instead.
+func (store *EventBadgerV2) seqAt(tx *badger.Txn, id string, ts int64, after bool) (uint64, bool, error) {
+	indexed, err := store.indexed(tx, id)
+	if err != nil || !indexed {
+		return store.scanSeqAt(tx, id, ts, after)
+	}
+
+	it := tx.NewIterator(badger.IteratorOptions{Reverse: !after})
+	defer it.Close()
+
+	prefix := store.timestampsPrefix(id)
+	key := store.indexKey(prefix, ts, math.MaxUint64)
+	if after {
+		key = store.indexKey(prefix, ts, 0)
+	}
+	if it.Seek(key); !it.ValidForPrefix([]byte(prefix)) {
+		return 0, false, nil
+	}
+
+	_, seq := store.parseIndexKey(prefix, it.Item().Key())
+	return seq, true, nil
+}
+
+// indexed returns if the events of a session were indexed, which is the case if its first event is.
+// Events which can't be parsed aren't indexed, so their sessions are considered not indexed.
+func (store *EventBadgerV2) indexed(tx *badger.Txn, id string) (bool, error) {
+	item, err := tx.Get(store.messageKey(id, 1))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var ts int64
+	err = item.Value(func(b []byte) error {
+		msg, err := store.decode(item.UserMeta(), b)
+		if err != nil {
+			return err
+		}
+		events, err := rrweb.Parse(msg)
+		if err != nil {
+			return err
+		}
+		ts = events[0].Timestamp
+		return nil
+	})
+	if err != nil {
+		return false, nil
+	}
+
+	_, err = tx.Get(store.indexKey(store.timestampsPrefix(id), ts, 1))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// scanSeqAt is seqAt for sessions which weren't indexed, parsing their events in order. Events which
+// can't be parsed are skipped.
+func (store *EventBadgerV2) scanSeqAt(tx *badger.Txn, id string, ts int64, after bool) (seq uint64, ok bool, err error) {
+	it := tx.NewIterator(badger.IteratorOptions{PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+
+	prefix := []byte(store.id(id))
+	for it.Seek(store.messageKey(id, 1)); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		var e *rrweb.Event
+		err := item.Value(func(b []byte) error {
+			msg, err := store.decode(item.UserMeta(), b)
+			if err != nil {
+				return err
+			}
+			if events, err := rrweb.Parse(msg); err == nil {
+				e = &events[0]
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, false, err
+		}
+		if e == nil {
+			continue
+		}
+
+		current := binary.BigEndian.Uint64(item.Key()[len(prefix):])
+		if after && e.Timestamp >= ts {
+			return current, true, nil
+		}
+		if !after && e.Timestamp <= ts {
+			seq, ok = current, true
+		}
+	}
+	return seq, ok, nil
 }
 
 func (store *EventBadgerV2) writeMsg(tx *badger.Txn, id string, seq uint64, msg []byte) error {
-	return tx.Set(store.messageKey(id, seq), msg)
+	b, err := store.codec.Compress(msg)
+	if err != nil {
+		return err
+	}
+
+	eventBytes.WithLabelValues("raw").Add(float64(len(msg)))
+	eventBytes.WithLabelValues("stored").Add(float64(len(b)))
+	return tx.SetEntry(badger.NewEntry(store.messageKey(id, seq), b).WithMeta(eventFormatZstd))
+}
+
+// decode returns the JSON of a stored event, according to its format
+func (store *EventBadgerV2) decode(format byte, b []byte) ([]byte, error) {
+	switch format {
+	case eventFormatRaw:
+		return b, nil
+	case eventFormatZstd:
+		return store.codec.Decompress(b)
+	}
+	return nil, fmt.Errorf("unknown event format %d", format)
+}
+
+// indexMsg indexes the timestamp of an event and, if it is a full snapshot, its keyframe. Events which
+// can't be parsed aren't indexed, as they are still replayable from the start.
+func (store *EventBadgerV2) indexMsg(tx *badger.Txn, id string, seq uint64, msg []byte) error {
+	events, err := rrweb.Parse(msg)
+	if err != nil {
+		return nil
+	}
+
+	e := events[0]
+	if err := tx.Set(store.indexKey(store.timestampsPrefix(id), e.Timestamp, seq), []byte{}); err != nil {
+		return err
+	}
+	if e.Type == rrweb.EventFullSnapshot {
+		return tx.Set(store.indexKey(store.keyframesPrefix(id), e.Timestamp, seq), []byte{})
+	}
+	return nil
+}
+
+// indexKey returns the key of an index entry: {prefix}{timestamp}{event_sequential_id}
+func (store *EventBadgerV2) indexKey(prefix string, ts int64, seq uint64) []byte {
+	key := make([]byte, len(prefix)+16)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(ts))
+	binary.BigEndian.PutUint64(key[len(prefix)+8:], seq)
+
+	return key
+}
+
+func (store *EventBadgerV2) parseIndexKey(prefix string, key []byte) (ts int64, seq uint64) {
+	return int64(binary.BigEndian.Uint64(key[len(prefix):])), binary.BigEndian.Uint64(key[len(prefix)+8:])
 }
 
 func (store *EventBadgerV2) messageKey(id string, seq uint64) []byte {
@@ -157,3 +489,207 @@ func (store *EventBadgerV2) messageKey(id string, seq uint64) []byte {
 func (store *EventBadgerV2) id(id string) string {
 	return "events/" + id + "/"
 }
+
+// timestampsPrefix is the prefix of the index from rrweb timestamps to sequential IDs:
+// ts/{session_id}/{timestamp}{event_sequential_id}
+func (store *EventBadgerV2) timestampsPrefix(id string) string {
+	return "ts/" + id + "/"
+}
+
+// keyframesPrefix is the prefix of the index of full snapshots: keyframes/{session_id}/{timestamp}{event_sequential_id}
+func (store *EventBadgerV2) keyframesPrefix(id string) string {
+	return "keyframes/" + id + "/"
+}
+
+// HasDictionary returns if events are compressed with a trained dictionary
+func (store *EventBadgerV2) HasDictionary() bool {
+	return store.codec.Current() != 0
+}
+
+// TrainDictionary trains a compression dictionary from a random sample of the stored events, which is
+// used for events added from now on. Events compressed with previous dictionaries remain readable,
+// being re-compressed by Compress.
+func (store *EventBadgerV2) TrainDictionary(ctx context.Context, samples, size int) (uint32, error) {
+	sample := make([][]byte, 0, samples)
+	seen := 0
+	err := store.eachEvent(ctx, func(item *badger.Item, b []byte) error {
+		msg, err := store.decode(item.UserMeta(), b)
+		if err != nil || len(msg) == 0 {
+			return err
+		}
+		if len(msg) > maxSampleSize {
+			msg = msg[:maxSampleSize]
+		}
+
+		// reservoir sampling, so all events have the same chance of being sampled
+		seen++
+		if len(sample) < samples {
+			sample = append(sample, append([]byte{}, msg...))
+		} else if i := rand.Intn(seen); i < samples {
+			sample[i] = append([]byte{}, msg...)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	dict, err := zstdict.Train(sample, size)
+	if err != nil {
+		return 0, err
+	}
+
+	id := zstdict.ID(dict)
+	err = store.db.Update(func(tx *badger.Txn) error {
+		if err := tx.Set(dictKey(id), dict); err != nil {
+			return err
+		}
+		return tx.Set([]byte(currentDictKey), dictKey(id)[len(dictPrefix):])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return store.codec.AddDict(dict, true)
+}
+
+// Compress re-compresses stored events which are raw (stored before compression was introduced) or
+// were compressed with a dictionary other than the current one
+func (store *EventBadgerV2) Compress(ctx context.Context) (stats CompressStats, err error) {
+	current := store.codec.Current()
+
+	keys := [][]byte{}
+	err = store.eachEvent(ctx, func(item *badger.Item, b []byte) error {
+		if item.UserMeta() == eventFormatZstd {
+			if id, err := zstdict.DictID(b); err != nil || id == current {
+				return err
+			}
+		}
+		keys = append(keys, item.KeyCopy(nil))
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// keys are re-read when compressed, so events deleted in the meantime are skipped
+	const batchSize = 1000
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		keys = keys[len(batch):]
+
+		batchStats := CompressStats{}
+		err := store.db.Update(func(tx *badger.Txn) error {
+			for _, key := range batch {
+				item, err := tx.Get(key)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				b, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				msg, err := store.decode(item.UserMeta(), b)
+				if err != nil {
+					return err
+				}
+				compressed, err := store.codec.Compress(msg)
+				if err != nil {
+					return err
+				}
+				if err := tx.SetEntry(badger.NewEntry(key, compressed).WithMeta(eventFormatZstd)); err != nil {
+					return err
+				}
+
+				batchStats.Events++
+				batchStats.RawBytes += uint64(len(msg))
+				batchStats.BytesBefore += uint64(len(b))
+				batchStats.BytesAfter += uint64(len(compressed))
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+
+		stats.Events += batchStats.Events
+		stats.RawBytes += batchStats.RawBytes
+		stats.BytesBefore += batchStats.BytesBefore
+		stats.BytesAfter += batchStats.BytesAfter
+	}
+
+	return stats, nil
+}
+
+// eachEvent iterates over the stored (not decoded) events of all sessions, skipping the sequence initialisers
+func (store *EventBadgerV2) eachEvent(ctx context.Context, fn func(item *badger.Item, b []byte) error) error {
+	return store.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte("events/")
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			item := it.Item()
+			key := item.Key()
+			if binary.BigEndian.Uint64(key[len(key)-8:]) == 0 {
+				continue
+			}
+
+			if err := item.Value(func(b []byte) error { return fn(item, b) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadDicts loads the stored compression dictionaries, using the current one to compress new events
+func (store *EventBadgerV2) loadDicts() error {
+	return store.db.View(func(tx *badger.Txn) error {
+		var current []byte
+		item, err := tx.Get([]byte(currentDictKey))
+		if err == nil {
+			current, err = item.ValueCopy(nil)
+		}
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(dictPrefix)
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			isCurrent := string(item.Key()[len(dictPrefix):]) == string(current)
+			err := item.Value(func(dict []byte) error {
+				_, err := store.codec.AddDict(dict, isCurrent)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func dictKey(id uint32) []byte {
+	key := make([]byte, len(dictPrefix)+4)
+	copy(key, dictPrefix)
+	binary.BigEndian.PutUint32(key[len(dictPrefix):], id)
+	return key
+}
@@ -0,0 +1,294 @@
+package repo_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func openBadger(t *testing.T, dir string) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLoggingLevel(badger.ERROR))
+	require.NoError(t, err)
+	return db
+}
+
+func getEvents(t *testing.T, store *repo.EventBadgerV2, id string) []string {
+	out := []string{}
+	require.NoError(t, store.Get(context.Background(), id, func(b []byte, pos, size uint64) error {
+		out = append(out, string(b))
+		return nil
+	}))
+	return out
+}
+
+func TestEventBadgerCompression(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := openBadger(t, dir)
+
+	// events stored before compression was introduced are raw JSON
+	legacy := []string{}
+	require.NoError(t, db.Update(func(tx *badger.Txn) error {
+		for seq := uint64(0); seq <= 3; seq++ {
+			key := make([]byte, len("events/legacy/")+8)
+			copy(key, "events/legacy/")
+			binary.BigEndian.PutUint64(key[len("events/legacy/"):], seq)
+
+			msg := ""
+			if seq > 0 {
+				msg = fmt.Sprintf(`{"type":3,"timestamp":%d}`, seq)
+				legacy = append(legacy, msg)
+			}
+			if err := tx.Set(key, []byte(msg)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	store, err := repo.NewEventBadger(db)
+	require.NoError(t, err)
+	require.False(t, store.HasDictionary())
+	require.Equal(t, legacy, getEvents(t, store, "legacy"))
+
+	events := []string{}
+	msgs := [][]byte{}
+	for i := 0; i < 500; i++ {
+		msg := fmt.Sprintf(`{"type":3,"data":{"source":1,"positions":[{"x":%d,"y":%d,"id":%d,"timeOffset":0}]},"timestamp":%d}`, i*7%1280, i*3%720, i%40, 1615000000000+i*16)
+		events = append(events, msg)
+		msgs = append(msgs, []byte(msg))
+	}
+	require.NoError(t, store.Add(ctx, "a", msgs...))
+	require.Equal(t, events, getEvents(t, store, "a"))
+
+	id, err := store.TrainDictionary(ctx, 1000, 4096)
+	require.NoError(t, err)
+	require.NotZero(t, id)
+	require.True(t, store.HasDictionary())
+
+	stats, err := store.Compress(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(legacy)+len(events)), stats.Events)
+	require.Less(t, stats.BytesAfter, stats.RawBytes)
+
+	// events are only re-compressed once per dictionary
+	stats, err = store.Compress(ctx)
+	require.NoError(t, err)
+	require.Zero(t, stats.Events)
+
+	require.NoError(t, store.Add(ctx, "a", []byte(`{"type":4}`)))
+	require.Equal(t, append(events, `{"type":4}`), getEvents(t, store, "a"))
+	require.Equal(t, legacy, getEvents(t, store, "legacy"))
+
+	// dictionaries are loaded when the storage is opened again
+	require.NoError(t, db.Close())
+	db = openBadger(t, dir)
+	t.Cleanup(func() { db.Close() })
+
+	store, err = repo.NewEventBadger(db)
+	require.NoError(t, err)
+	require.True(t, store.HasDictionary())
+	require.Equal(t, legacy, getEvents(t, store, "legacy"))
+	require.Len(t, getEvents(t, store, "a"), len(events)+1)
+}
+
+func TestEventBadgerKeyframes(t *testing.T) {
+	ctx := context.Background()
+	db := openBadger(t, t.TempDir())
+	defer db.Close()
+
+	store, err := repo.NewEventBadger(db)
+	require.NoError(t, err)
+
+	// keyframes are full snapshots (type 2), starting from the meta event (type 4) which precedes them
+	types := []int{4, 2, 3, 3, 3, 4, 2, 3, 3}
+	msgs := [][]byte{}
+	for i, typ := range types {
+		msgs = append(msgs, []byte(fmt.Sprintf(`{"type":%d,"data":{},"timestamp":%d}`, typ, 1000+i*100)))
+	}
+	require.NoError(t, store.Add(ctx, "a", msgs...))
+
+	// session b is only stored so index lookups are shown to not leak across sessions
+	require.NoError(t, store.Add(ctx, "b", []byte(`{"type":4,"data":{},"timestamp":10}`), []byte(`{"type":2,"data":{},"timestamp":10}`)))
+
+	tests := []struct {
+		name  string
+		ts    int64
+		ok    bool
+		kf    repo.Keyframe
+		seqOK bool
+		seq   uint64
+	}{
+		{name: "before the session", ts: 500, seqOK: false},
+		{name: "before the first keyframe", ts: 1050, seqOK: true, seq: 1},
+		{name: "at the first keyframe", ts: 1100, ok: true, kf: repo.Keyframe{Seq: 1, Timestamp: 1100}, seqOK: true, seq: 2},
+		{name: "between keyframes", ts: 1450, ok: true, kf: repo.Keyframe{Seq: 1, Timestamp: 1100}, seqOK: true, seq: 5},
+		{name: "at the second keyframe", ts: 1600, ok: true, kf: repo.Keyframe{Seq: 6, Timestamp: 1600}, seqOK: true, seq: 7},
+		{name: "after the session", ts: 5000, ok: true, kf: repo.Keyframe{Seq: 6, Timestamp: 1600}, seqOK: true, seq: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kf, ok, err := store.KeyframeAt(ctx, "a", tt.ts)
+			require.NoError(t, err)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.kf, kf)
+
+			seq, ok, err := store.SeqAt(ctx, "a", tt.ts)
+			require.NoError(t, err)
+			require.Equal(t, tt.seqOK, ok)
+			require.Equal(t, tt.seq, seq)
+		})
+	}
+
+	t.Run("get from a keyframe sequence", func(t *testing.T) {
+		out := []string{}
+		positions := []uint64{}
+		next, err := store.GetRange(ctx, "a", repo.EventRange{FromSeq: 6}, func(b []byte, pos, size uint64) error {
+			out = append(out, string(b))
+			positions = append(positions, pos)
+			require.Equal(t, uint64(4), size)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Empty(t, next)
+		require.Equal(t, []string{string(msgs[5]), string(msgs[6]), string(msgs[7]), string(msgs[8])}, out)
+		require.Equal(t, []uint64{0, 1, 2, 3}, positions)
+	})
+
+	t.Run("index events stored before indexing", func(t *testing.T) {
+		require.NoError(t, db.Update(func(tx *badger.Txn) error {
+			for _, prefix := range []string{"ts/a/", "keyframes/a/"} {
+				it := tx.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix)})
+				keys := [][]byte{}
+				for it.Rewind(); it.Valid(); it.Next() {
+					keys = append(keys, it.Item().KeyCopy(nil))
+				}
+				it.Close()
+				for _, k := range keys {
+					if err := tx.Delete(k); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}))
+
+		_, ok, err := store.KeyframeAt(ctx, "a", 1600)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		count, err := store.Index(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(msgs)+2), count)
+
+		kf, ok, err := store.KeyframeAt(ctx, "a", 1600)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, repo.Keyframe{Seq: 6, Timestamp: 1600}, kf)
+	})
+
+	t.Run("delete removes the indexes", func(t *testing.T) {
+		require.NoError(t, store.Delete(ctx, "a"))
+
+		_, ok, err := store.KeyframeAt(ctx, "a", 1600)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, ok, err = store.SeqAt(ctx, "a", 1600)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		kf, ok, err := store.KeyframeAt(ctx, "b", 1600)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, repo.Keyframe{Seq: 1, Timestamp: 10}, kf)
+	})
+}
+
+func TestEventBadgerRange(t *testing.T) {
+	ctx := context.Background()
+	db := openBadger(t, t.TempDir())
+	defer db.Close()
+
+	store, err := repo.NewEventBadger(db)
+	require.NoError(t, err)
+
+	// events 1 to 10, recorded every 100ms from 1000
+	msgs := [][]byte{}
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, []byte(fmt.Sprintf(`{"type":3,"data":{},"timestamp":%d}`, 1000+i*100)))
+	}
+	require.NoError(t, store.Add(ctx, "a", msgs...))
+
+	// the same events, stored before events were indexed
+	require.NoError(t, db.Update(func(tx *badger.Txn) error {
+		for i, msg := range msgs {
+			key := make([]byte, len("events/legacy/")+8)
+			copy(key, "events/legacy/")
+			binary.BigEndian.PutUint64(key[len("events/legacy/"):], uint64(i+1))
+			if err := tx.Set(key, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// getPages gets all pages of a range, returning the timestamps of each page events
+	getPages := func(t *testing.T, id string, rng repo.EventRange) [][]int {
+		pages := [][]int{}
+		for {
+			page := []int{}
+			next, err := store.GetRange(ctx, id, rng, func(b []byte, pos, size uint64) error {
+				require.Equal(t, uint64(len(page)), pos)
+				var e struct{ Timestamp int }
+				require.NoError(t, json.Unmarshal(b, &e))
+				page = append(page, e.Timestamp)
+				return nil
+			})
+			require.NoError(t, err)
+			pages = append(pages, page)
+			if next == "" {
+				return pages
+			}
+			rng.Cursor = next
+		}
+	}
+
+	tests := []struct {
+		name string
+		rng  repo.EventRange
+		want [][]int
+	}{
+		{name: "all events", rng: repo.EventRange{}, want: [][]int{{1000, 1100, 1200, 1300, 1400, 1500, 1600, 1700, 1800, 1900}}},
+		{name: "sequences", rng: repo.EventRange{FromSeq: 3, ToSeq: 5}, want: [][]int{{1200, 1300, 1400}}},
+		{name: "sequences past the end", rng: repo.EventRange{FromSeq: 9, ToSeq: 50}, want: [][]int{{1800, 1900}}},
+		{name: "empty sequences", rng: repo.EventRange{FromSeq: 11}, want: [][]int{{}}},
+		{name: "timestamps", rng: repo.EventRange{FromTs: 1150, ToTs: 1400}, want: [][]int{{1200, 1300, 1400}}},
+		{name: "timestamps after the session", rng: repo.EventRange{FromTs: 5000}, want: [][]int{{}}},
+		{name: "timestamps before the session", rng: repo.EventRange{ToTs: 500}, want: [][]int{{}}},
+		{name: "sequences and timestamps", rng: repo.EventRange{FromSeq: 4, FromTs: 1100, ToTs: 1700}, want: [][]int{{1300, 1400, 1500, 1600, 1700}}},
+		{name: "pages", rng: repo.EventRange{Limit: 4}, want: [][]int{{1000, 1100, 1200, 1300}, {1400, 1500, 1600, 1700}, {1800, 1900}}},
+		{name: "pages of a range", rng: repo.EventRange{FromSeq: 2, ToTs: 1600, Limit: 3}, want: [][]int{{1100, 1200, 1300}, {1400, 1500, 1600}}},
+	}
+
+	for _, id := range []string{"a", "legacy"} {
+		for _, tt := range tests {
+			t.Run(id+"/"+tt.name, func(t *testing.T) {
+				require.Equal(t, tt.want, getPages(t, id, tt.rng))
+			})
+		}
+	}
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := store.GetRange(ctx, "a", repo.EventRange{Cursor: "nope"}, func(b []byte, pos, size uint64) error { return nil })
+		require.True(t, errors.Is(err, repo.ErrInvalidCursor), err)
+	})
+}
@@ -0,0 +1,428 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/zstd"
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/brunoluiz/jornada/internal/storage/filedb"
+)
+
+const (
+	eventIndexFile = "index.ndjson"
+
+	// maxSessionPathName is the longest file name most file systems allow
+	maxSessionPathName = 255
+)
+
+// sessionIDRegex matches the session IDs which are used as they are in paths (and object keys). Other
+// IDs are encoded as "~" followed by their unpadded base64url encoding (see sessionPathName).
+var sessionIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// EventFile defines an event storage using append-only files, so recordings can be inspected, synced
+// and backed up with ordinary tools. Each session has its own directory (named after its ID, which is
+// encoded if it isn't made of letters, digits, "_" and "-"), with the following files:
+//
+//	{segment}.ndjson (or .ndjson.zst): events, one per line. Each Add appends a block of events to the
+//	last segment, which is rotated once it is larger than the configured segment size. Compressed
+//	blocks are zstd frames, so segments can be read with `zstd -dc`.
+//	index.ndjson: the position, sequences, timestamps and keyframes of each block, one per line.
+//
+// Blocks are written before their index entries, so blocks left by failed writes are ignored, as well
+// as index entries which were partially written.
+type EventFile struct {
+	store   *filedb.FileStore
+	mu      sync.Mutex
+	metrics bool // if set, added events are tracked by jornada_events_bytes_total
+}
+
+// eventBlock is an index entry, with the position of a block of events in a segment. Timestamps are
+// the range of rrweb timestamps of its events, being 0 if none could be parsed.
+type eventBlock struct {
+	Segment    int        `json:"segment"`
+	Compressed bool       `json:"zstd,omitempty"`
+	Offset     int64      `json:"offset"`
+	Size       int64      `json:"size"`
+	Seq        uint64     `json:"seq"`
+	Count      uint64     `json:"count"`
+	FromTs     int64      `json:"from_ts,omitempty"`
+	ToTs       int64      `json:"to_ts,omitempty"`
+	Keyframes  []Keyframe `json:"keyframes,omitempty"`
+}
+
+// NewEventFile returns a new *EventFile
+func NewEventFile(store *filedb.FileStore) *EventFile {
+	return &EventFile{store: store, metrics: true}
+}
+
+// Add bulk adds events for a certain session id, as a new block in its last segment
+func (store *EventFile) Add(ctx context.Context, sessionID string, msgs ...[]byte) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	dir, err := store.dir(sessionID)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	blocks, partial, err := store.blocks(dir)
+	if err != nil {
+		return err
+	}
+
+	block := eventBlock{Segment: 1, Compressed: store.store.Compress, Seq: 1, Count: uint64(len(msgs))}
+	if len(blocks) > 0 {
+		last := blocks[len(blocks)-1]
+		block.Segment, block.Seq = last.Segment, last.Seq+last.Count
+		if last.Compressed != block.Compressed || last.Offset+last.Size >= store.store.SegmentSize {
+			block.Segment++
+		}
+	}
+
+	var raw bytes.Buffer
+	for i, msg := range msgs {
+		// events are written one per line, which JSON allows as long as it is compact
+		if bytes.IndexByte(msg, '\n') >= 0 {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, msg); err != nil {
+				return err
+			}
+			msg = compact.Bytes()
+		}
+		raw.Write(msg)
+		raw.WriteByte('\n')
+
+		events, err := rrweb.Parse(msg)
+		if err != nil {
+			continue
+		}
+		e := events[0]
+		if block.FromTs == 0 || e.Timestamp < block.FromTs {
+			block.FromTs = e.Timestamp
+		}
+		if e.Timestamp > block.ToTs {
+			block.ToTs = e.Timestamp
+		}
+		if e.Type == rrweb.EventFullSnapshot {
+			block.Keyframes = append(block.Keyframes, Keyframe{Seq: block.Seq + uint64(i), Timestamp: e.Timestamp})
+		}
+	}
+
+	b := raw.Bytes()
+	if block.Compressed {
+		if b, err = zstd.CompressLevel(nil, b, 3); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if block.Offset, err = appendFile(filepath.Join(dir, block.segmentName()), b); err != nil {
+		return err
+	}
+	block.Size = int64(len(b))
+
+	entry, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	if partial {
+		entry = append([]byte{'\n'}, entry...)
+	}
+	if _, err := appendFile(filepath.Join(dir, eventIndexFile), append(entry, '\n')); err != nil {
+		return err
+	}
+
+	if store.metrics {
+		eventBytes.WithLabelValues("raw").Add(float64(raw.Len()))
+		eventBytes.WithLabelValues("stored").Add(float64(len(b)))
+	}
+	return nil
+}
+
+// Get all events for a certain session id
+func (store *EventFile) Get(ctx context.Context, sessionID string, cb func(b []byte, pos, size uint64) error) error {
+	_, err := store.GetRange(ctx, sessionID, EventRange{}, cb)
+	return err
+}
+
+// GetRange get events for a certain session id within a range, in order (see EventBadgerV2.GetRange)
+func (store *EventFile) GetRange(ctx context.Context, sessionID string, rng EventRange, cb func(b []byte, pos, size uint64) error) (string, error) {
+	rng, err := rng.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := store.dir(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	blocks, _, err := store.blocks(dir)
+	if err != nil || len(blocks) == 0 {
+		return "", err
+	}
+
+	last := blocks[len(blocks)-1]
+	from, size, next, err := rng.bounds(last.Seq+last.Count-1, func(ts int64, after bool) (uint64, bool, error) {
+		return store.seqAt(dir, blocks, ts, after)
+	})
+	if err != nil || size == 0 {
+		return "", err
+	}
+
+	var count uint64
+	for _, block := range blocks {
+		if block.Seq+block.Count <= from {
+			continue
+		}
+		if count == size {
+			break
+		}
+
+		msgs, err := store.readBlock(dir, block)
+		if err != nil {
+			return "", err
+		}
+		for i, msg := range msgs {
+			if block.Seq+uint64(i) < from {
+				continue
+			}
+			if count == size {
+				break
+			}
+			if err := cb(msg, count, size); err != nil {
+				return "", err
+			}
+			count++
+		}
+	}
+
+	return next, nil
+}
+
+// KeyframeAt returns the last keyframe recorded at or before an rrweb timestamp (see EventBadgerV2.KeyframeAt)
+func (store *EventFile) KeyframeAt(ctx context.Context, sessionID string, ts int64) (kf Keyframe, ok bool, err error) {
+	dir, err := store.dir(sessionID)
+	if err != nil {
+		return kf, false, err
+	}
+
+	blocks, _, err := store.blocks(dir)
+	if err != nil {
+		return kf, false, err
+	}
+
+	for i := len(blocks) - 1; i >= 0 && !ok; i-- {
+		for j := len(blocks[i].Keyframes) - 1; j >= 0; j-- {
+			if blocks[i].Keyframes[j].Timestamp <= ts {
+				kf, ok = blocks[i].Keyframes[j], true
+				break
+			}
+		}
+	}
+	if !ok || kf.Seq <= 1 {
+		return kf, ok, nil
+	}
+
+	// the meta event which precedes the full snapshot might have been added in a previous block
+	for _, block := range blocks {
+		if kf.Seq-1 < block.Seq || kf.Seq-1 >= block.Seq+block.Count {
+			continue
+		}
+
+		msgs, err := store.readBlock(dir, block)
+		if err != nil {
+			return kf, false, err
+		}
+		if events, err := rrweb.Parse(msgs[kf.Seq-1-block.Seq]); err == nil && events[0].Type == rrweb.EventMeta {
+			kf.Seq--
+		}
+		break
+	}
+
+	return kf, true, nil
+}
+
+// Delete delete events from the storage, based on their session ids
+func (store *EventFile) Delete(ctx context.Context, ids ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, id := range ids {
+		dir, err := store.dir(id)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seqAt returns the sequence of the last event recorded at or before ts, or the first event recorded at
+// or after it if after is set. Blocks are narrowed down by their timestamps, assuming events are added
+// in order, with the events of the matching block being parsed.
+func (store *EventFile) seqAt(dir string, blocks []eventBlock, ts int64, after bool) (uint64, bool, error) {
+	for i := range blocks {
+		block := blocks[i]
+		if !after {
+			block = blocks[len(blocks)-1-i]
+		}
+		if block.ToTs == 0 || (after && block.ToTs < ts) || (!after && block.FromTs > ts) {
+			continue
+		}
+
+		msgs, err := store.readBlock(dir, block)
+		if err != nil {
+			return 0, false, err
+		}
+		for j := range msgs {
+			k := j
+			if !after {
+				k = len(msgs) - 1 - j
+			}
+			events, err := rrweb.Parse(msgs[k])
+			if err != nil {
+				continue
+			}
+			if (after && events[0].Timestamp >= ts) || (!after && events[0].Timestamp <= ts) {
+				return block.Seq + uint64(k), true, nil
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// blocks reads the index of a session, returning if it ends with an entry which is partial (not
+// terminated by a new line). Partial entries are either being written or were left by failed writes,
+// being ignored.
+func (store *EventFile) blocks(dir string) (blocks []eventBlock, partial bool, err error) {
+	f, err := os.Open(filepath.Join(dir, eventIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return blocks, len(line) > 0, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		var block eventBlock
+		if err := json.Unmarshal(line, &block); err != nil {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+// readBlock returns the events of a block
+func (store *EventFile) readBlock(dir string, block eventBlock) ([][]byte, error) {
+	f, err := os.Open(filepath.Join(dir, block.segmentName()))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, block.Size)
+	if _, err := f.ReadAt(b, block.Offset); err != nil {
+		return nil, err
+	}
+	if block.Compressed {
+		if b, err = zstd.Decompress(nil, b); err != nil {
+			return nil, err
+		}
+	}
+
+	msgs := bytes.Split(bytes.TrimSuffix(b, []byte{'\n'}), []byte{'\n'})
+	if uint64(len(msgs)) != block.Count {
+		return nil, fmt.Errorf("events block at %s:%d has %d events instead of %d", block.segmentName(), block.Offset, len(msgs), block.Count)
+	}
+	return msgs, nil
+}
+
+func (store *EventFile) dir(sessionID string) (string, error) {
+	name, err := sessionPathName(sessionID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store.store.Path, name), nil
+}
+
+// sessionPathName returns the name used for a session in paths and object keys. IDs with characters
+// which aren't safe in paths are encoded, so any ID can be stored.
+func sessionPathName(sessionID string) (string, error) {
+	name := sessionID
+	if !sessionIDRegex.MatchString(sessionID) {
+		name = "~" + base64.RawURLEncoding.EncodeToString([]byte(sessionID))
+	}
+	if sessionID == "" || len(name) > maxSessionPathName {
+		return "", fmt.Errorf("invalid session id %q", sessionID)
+	}
+	return name, nil
+}
+
+// sessionFromPathName returns the session ID of a name returned by sessionPathName
+func sessionFromPathName(name string) (string, bool) {
+	if !strings.HasPrefix(name, "~") {
+		return name, sessionIDRegex.MatchString(name)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(name[1:])
+	if err != nil || len(b) == 0 {
+		return "", false
+	}
+	return string(b), true
+}
+
+func (block eventBlock) segmentName() string {
+	if block.Compressed {
+		return fmt.Sprintf("%06d.ndjson.zst", block.Segment)
+	}
+	return fmt.Sprintf("%06d.ndjson", block.Segment)
+}
+
+// appendFile appends b to a file, returning the offset it was written at
+func appendFile(name string, b []byte) (int64, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(b); err != nil {
+		return 0, err
+	}
+	return info.Size(), f.Close()
+}
@@ -0,0 +1,193 @@
+package repo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/zstd"
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/storage/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFile(t *testing.T) {
+	ctx := context.Background()
+
+	// events 1 to 12, recorded every 100ms from 1000, with keyframes (meta + full snapshot) at 1000 and 1600.
+	// They are added in blocks of 3, so the second keyframe meta event (6) is in a different block.
+	types := []int{4, 2, 3, 3, 3, 4, 2, 3, 3, 3, 3, 3}
+	events := []string{}
+	for i, typ := range types {
+		events = append(events, fmt.Sprintf(`{"type":%d,"data":{},"timestamp":%d}`, typ, 1000+i*100))
+	}
+	events[2] = "{\n  \"type\": 3,\n  \"data\": {},\n  \"timestamp\": 1200\n}"
+
+	tests := []struct {
+		name     string
+		dsn      string
+		segments []string
+	}{
+		{name: "raw", dsn: "?segment_size=200", segments: []string{"000001.ndjson", "000002.ndjson"}},
+		{name: "compressed", dsn: "?compress=zstd", segments: []string{"000001.ndjson.zst"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fs, err := filedb.New("file://" + dir + tt.dsn)
+			require.NoError(t, err)
+			store := repo.NewEventFile(fs)
+
+			for i := 0; i < len(events); i += 3 {
+				require.NoError(t, store.Add(ctx, "a", []byte(events[i]), []byte(events[i+1]), []byte(events[i+2])))
+			}
+			require.NoError(t, store.Add(ctx, "b", []byte(events[0])))
+
+			all := []string{}
+			require.NoError(t, store.Get(ctx, "a", func(b []byte, pos, size uint64) error {
+				require.Equal(t, uint64(len(all)), pos)
+				require.Equal(t, uint64(len(events)), size)
+				all = append(all, string(b))
+				return nil
+			}))
+			require.Len(t, all, len(events))
+			require.Equal(t, `{"type":3,"data":{},"timestamp":1200}`, all[2])
+			require.Equal(t, events[11], all[11])
+
+			// segments hold one event per line, readable without jornada. Compressed blocks are zstd frames,
+			// which are decompressed one by one, as the zstd reader doesn't support concatenated frames.
+			index, err := os.ReadFile(filepath.Join(dir, "a", "index.ndjson"))
+			require.NoError(t, err)
+
+			lines := []string{}
+			for _, entry := range strings.Split(strings.TrimSpace(string(index)), "\n") {
+				var block struct {
+					Segment int
+					Zstd    bool
+					Offset  int64
+					Size    int64
+				}
+				require.NoError(t, json.Unmarshal([]byte(entry), &block))
+
+				name := fmt.Sprintf("%06d.ndjson", block.Segment)
+				if block.Zstd {
+					name += ".zst"
+				}
+				require.Contains(t, tt.segments, name)
+
+				segment, err := os.ReadFile(filepath.Join(dir, "a", name))
+				require.NoError(t, err)
+				b := segment[block.Offset : block.Offset+block.Size]
+				if block.Zstd {
+					b, err = zstd.Decompress(nil, b)
+					require.NoError(t, err)
+				}
+				lines = append(lines, strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")...)
+			}
+			require.Equal(t, all, lines)
+
+			pages := [][]string{}
+			rng := repo.EventRange{FromSeq: 2, ToTs: 1750, Limit: 4}
+			for {
+				page := []string{}
+				next, err := store.GetRange(ctx, "a", rng, func(b []byte, pos, size uint64) error {
+					page = append(page, string(b))
+					return nil
+				})
+				require.NoError(t, err)
+				pages = append(pages, page)
+				if next == "" {
+					break
+				}
+				rng.Cursor = next
+			}
+			require.Equal(t, [][]string{all[1:5], all[5:8]}, pages)
+
+			page := []string{}
+			_, err = store.GetRange(ctx, "a", repo.EventRange{FromTs: 1150, ToSeq: 4}, func(b []byte, pos, size uint64) error {
+				page = append(page, string(b))
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, all[2:4], page)
+
+			kf, ok, err := store.KeyframeAt(ctx, "a", 1500)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, repo.Keyframe{Seq: 1, Timestamp: 1100}, kf)
+
+			kf, ok, err = store.KeyframeAt(ctx, "a", 1750)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, repo.Keyframe{Seq: 6, Timestamp: 1600}, kf)
+
+			_, ok, err = store.KeyframeAt(ctx, "a", 1050)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			// partially written index entries are ignored
+			f, err := os.OpenFile(filepath.Join(dir, "a", "index.ndjson"), os.O_APPEND|os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = f.WriteString(`{"segment":1,"off`)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			require.NoError(t, store.Add(ctx, "a", []byte(`{"type":3,"data":{},"timestamp":2200}`)))
+
+			all = []string{}
+			require.NoError(t, store.Get(ctx, "a", func(b []byte, pos, size uint64) error {
+				all = append(all, string(b))
+				return nil
+			}))
+			require.Len(t, all, len(events)+1)
+			require.Equal(t, `{"type":3,"data":{},"timestamp":2200}`, all[len(events)])
+
+			require.NoError(t, store.Delete(ctx, "a"))
+			require.NoDirExists(t, filepath.Join(dir, "a"))
+			require.NoError(t, store.Get(ctx, "a", func(b []byte, pos, size uint64) error {
+				return fmt.Errorf("unexpected event %s", b)
+			}))
+			require.DirExists(t, filepath.Join(dir, "b"))
+		})
+	}
+
+	t.Run("encoded session ids", func(t *testing.T) {
+		dir := t.TempDir()
+		fs, err := filedb.New("file://" + filepath.Join(dir, "events"))
+		require.NoError(t, err)
+		store := repo.NewEventFile(fs)
+
+		for _, id := range []string{"../a", "a/b", "user@example.com", "~a", "."} {
+			require.NoError(t, store.Add(ctx, id, []byte(events[0])), id)
+
+			all := []string{}
+			require.NoError(t, store.Get(ctx, id, func(b []byte, pos, size uint64) error {
+				all = append(all, string(b))
+				return nil
+			}))
+			require.Equal(t, []string{events[0]}, all, id)
+		}
+		require.NoDirExists(t, filepath.Join(dir, "a"))
+
+		entries, err := os.ReadDir(filepath.Join(dir, "events"))
+		require.NoError(t, err)
+		require.Len(t, entries, 5)
+
+		require.NoError(t, store.Delete(ctx, "../a"))
+		require.NoError(t, store.Get(ctx, "../a", func(b []byte, pos, size uint64) error {
+			return fmt.Errorf("unexpected event %s", b)
+		}))
+	})
+
+	t.Run("invalid session id", func(t *testing.T) {
+		fs, err := filedb.New("file://" + t.TempDir())
+		require.NoError(t, err)
+		for _, id := range []string{"", strings.Repeat("a", 256), strings.Repeat(".", 200)} {
+			require.Error(t, repo.NewEventFile(fs).Add(ctx, id, []byte(events[0])), id)
+		}
+	})
+}
@@ -0,0 +1,753 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/zstd"
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/brunoluiz/jornada/internal/storage/filedb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventPartExt = ".ndjson.zst"
+
+	// minFlushInterval bounds how often idle sessions are checked
+	minFlushInterval = 10 * time.Millisecond
+
+	// maxReadAttempts bounds how many times reads are retried when buffers are uploaded while being read
+	maxReadAttempts = 3
+)
+
+// errBufferFlushed is returned when a session buffer is uploaded while being read
+var errBufferFlushed = errors.New("events buffer was uploaded while being read")
+
+// ObjectStorage defines the object storage operations used by EventS3 (see s3db.S3Store). Keys are
+// relative to the storage prefix, with List returning all keys under a prefix.
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, b []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Remove(ctx context.Context, key string) error
+}
+
+// EventS3 defines an event storage using S3 compatible object storages, so nodes keep little state and
+// storage scales independently. Live sessions are buffered in a local EventFile, being uploaded once
+// they are idle (no events were added for a while) or the service stops. Each upload is an object with
+// a part of the session events, as NDJSON compressed with zstd:
+//
+//	{prefix}/{session_id}/{first_seq}-{last_seq}_{from_ts}-{to_ts}_{keyframe_ts}.ndjson.zst
+//
+// Part keys have the range of rrweb timestamps of their events and the timestamp of their first full
+// snapshot (0 if there are none), so reads only download the parts they need. Session IDs are encoded
+// as in EventFile paths.
+//
+// Sessions resumed after being uploaded are buffered again, being uploaded as their following part.
+// Sequences of buffered events start after the uploaded ones, which are kept in the buffer
+// ({session_id}.seq) while the session is buffered.
+type EventS3 struct {
+	objects ObjectStorage
+	buffer  *EventFile
+	idle    time.Duration
+	log     *logrus.Logger
+	mu      sync.Mutex
+	flushMu sync.Mutex // serialises uploads, so parts aren't replaced by older ones
+	removed uint64     // count of removed buffers, so Add knows if listed parts are outdated
+}
+
+// eventPart is an uploaded part of a session events. Timestamps are the range of rrweb timestamps of
+// its events and the one of its first full snapshot, being 0 if there are none.
+type eventPart struct {
+	Key        string
+	First      uint64
+	Last       uint64
+	FromTs     int64
+	ToTs       int64
+	KeyframeTs int64
+}
+
+// eventView is where the events of a session were at a certain point: its uploaded parts and the
+// index of its buffer, along with the sequence of the first buffered event (0 if there are none)
+type eventView struct {
+	sessionID string
+	parts     []eventPart
+	first     uint64
+	blocks    []eventBlock
+}
+
+// NewEventS3 returns a new *EventS3, buffering events in bufferPath (created if it doesn't exist) until
+// they are idle
+func NewEventS3(objects ObjectStorage, bufferPath string, idle time.Duration, log *logrus.Logger) (*EventS3, error) {
+	buffer, err := filedb.New((&url.URL{Scheme: "file", Path: bufferPath}).String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventS3{objects: objects, buffer: &EventFile{store: buffer}, idle: idle, log: log}, nil
+}
+
+// Add bulk adds events for a certain session id to its buffer
+func (store *EventS3) Add(ctx context.Context, sessionID string, msgs ...[]byte) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	for {
+		// the buffer is checked while locked, so it can't be uploaded before the events are added
+		store.mu.Lock()
+		first, _, err := store.bufferState(sessionID)
+		if err != nil || first > 0 {
+			if err == nil {
+				err = store.buffer.Add(ctx, sessionID, msgs...)
+			}
+			store.mu.Unlock()
+			return err
+		}
+		removed := store.removed
+		store.mu.Unlock()
+
+		// sessions which aren't buffered might be resumed, so their sequences start after the uploaded ones.
+		// Parts are listed unlocked, so other sessions can be added meanwhile.
+		parts, err := store.parts(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+
+		var uploaded uint64
+		if len(parts) > 0 {
+			uploaded = parts[len(parts)-1].Last
+		}
+
+		ok, err := store.addUploaded(ctx, sessionID, removed, uploaded, msgs)
+		if ok || err != nil {
+			return err
+		}
+	}
+}
+
+// addUploaded starts the buffer of a session after the uploaded events, unless it was started meanwhile.
+// If buffers were removed since the parts were listed, they might have been uploaded as new parts, so
+// nothing is added and parts must be listed again.
+func (store *EventS3) addUploaded(ctx context.Context, sessionID string, removed, uploaded uint64, msgs [][]byte) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.removed != removed {
+		return false, nil
+	}
+
+	first, _, err := store.bufferState(sessionID)
+	if err != nil {
+		return false, err
+	}
+	if first == 0 {
+		seqPath, err := store.seqPath(sessionID)
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(seqPath, []byte(strconv.FormatUint(uploaded, 10)), 0o644); err != nil {
+			return false, err
+		}
+	}
+
+	return true, store.buffer.Add(ctx, sessionID, msgs...)
+}
+
+// Get all events for a certain session id
+func (store *EventS3) Get(ctx context.Context, sessionID string, cb func(b []byte, pos, size uint64) error) error {
+	_, err := store.GetRange(ctx, sessionID, EventRange{}, cb)
+	return err
+}
+
+// GetRange get events for a certain session id within a range, in order (see EventBadgerV2.GetRange).
+// Only the parts with events in the range are downloaded (along with the ones needed to resolve
+// timestamps), with the remaining events being read from the buffer.
+func (store *EventS3) GetRange(ctx context.Context, sessionID string, rng EventRange, cb func(b []byte, pos, size uint64) error) (string, error) {
+	rng, err := rng.withDefaults()
+	if err != nil {
+		return "", err
+	}
+
+	var msgs [][]byte
+	var next string
+	err = retryFlushed(func() (err error) {
+		msgs, next, err = store.getRange(ctx, sessionID, rng)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for i, msg := range msgs {
+		if err := cb(msg, uint64(i), uint64(len(msgs))); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (store *EventS3) getRange(ctx context.Context, sessionID string, rng EventRange) ([][]byte, string, error) {
+	v, err := store.view(ctx, sessionID)
+	if err != nil || v.last() == 0 {
+		return nil, "", err
+	}
+
+	from, size, next, err := rng.bounds(v.last(), func(ts int64, after bool) (uint64, bool, error) {
+		return store.seqAt(ctx, v, ts, after)
+	})
+	if err != nil || size == 0 {
+		return nil, "", err
+	}
+
+	msgs, err := store.read(ctx, v, from, from+size-1)
+	return msgs, next, err
+}
+
+// KeyframeAt returns the last keyframe recorded at or before an rrweb timestamp (see EventBadgerV2.KeyframeAt).
+// Keyframes are found through the buffer index and part keys, so only the part with the keyframe (and
+// the one with the preceding event, if it differs) is downloaded.
+func (store *EventS3) KeyframeAt(ctx context.Context, sessionID string, ts int64) (kf Keyframe, ok bool, err error) {
+	err = retryFlushed(func() (err error) {
+		kf, ok, err = store.keyframeAt(ctx, sessionID, ts)
+		return err
+	})
+	return kf, ok, err
+}
+
+func (store *EventS3) keyframeAt(ctx context.Context, sessionID string, ts int64) (kf Keyframe, ok bool, err error) {
+	v, err := store.view(ctx, sessionID)
+	if err != nil {
+		return kf, false, err
+	}
+
+	for i := len(v.blocks) - 1; i >= 0 && !ok; i-- {
+		for j := len(v.blocks[i].Keyframes) - 1; j >= 0; j-- {
+			if k := v.blocks[i].Keyframes[j]; k.Timestamp <= ts {
+				kf, ok = Keyframe{Seq: v.first + k.Seq - 1, Timestamp: k.Timestamp}, true
+				break
+			}
+		}
+	}
+
+	var prev []byte
+	for i := len(v.parts) - 1; i >= 0 && !ok; i-- {
+		part := v.parts[i]
+		if part.KeyframeTs == 0 || part.KeyframeTs > ts {
+			continue
+		}
+
+		msgs, err := store.readPart(ctx, part)
+		if err != nil {
+			return kf, false, err
+		}
+		for j := len(msgs) - 1; j >= 0; j-- {
+			events, err := rrweb.Parse(msgs[j])
+			if err != nil || events[0].Type != rrweb.EventFullSnapshot || events[0].Timestamp > ts {
+				continue
+			}
+
+			kf, ok = Keyframe{Seq: part.First + uint64(j), Timestamp: events[0].Timestamp}, true
+			if j > 0 {
+				prev = msgs[j-1]
+			}
+			break
+		}
+	}
+	if !ok || kf.Seq <= 1 {
+		return kf, ok, nil
+	}
+
+	// the meta event which precedes the full snapshot might be in a previous part
+	if prev == nil {
+		msgs, err := store.read(ctx, v, kf.Seq-1, kf.Seq-1)
+		if err != nil {
+			return kf, false, err
+		}
+		prev = msgs[0]
+	}
+	if events, err := rrweb.Parse(prev); err == nil && events[0].Type == rrweb.EventMeta {
+		kf.Seq--
+	}
+	return kf, true, nil
+}
+
+// Delete delete events from the storage (buffered and uploaded), based on their session ids
+func (store *EventS3) Delete(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		if err := store.delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete deletes the events of a session. Uploads are locked meanwhile, so a session being uploaded isn't
+// uploaded again after its parts are removed.
+func (store *EventS3) delete(ctx context.Context, sessionID string) error {
+	store.flushMu.Lock()
+	defer store.flushMu.Unlock()
+
+	seqPath, err := store.seqPath(sessionID)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	err = store.buffer.Delete(ctx, sessionID)
+	if err == nil {
+		err = removeFile(seqPath)
+	}
+	store.removed++
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	parts, err := store.listParts(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if err := store.objects.Remove(ctx, part.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run uploads buffered sessions once they are idle, until the context is done. Then, all buffered
+// sessions are uploaded, so the node can be replaced.
+func (store *EventS3) Run(ctx context.Context) error {
+	interval := store.idle / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < minFlushInterval {
+		interval = minFlushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			store.flushIdle(ctx, store.idle)
+		case <-ctx.Done():
+			store.flushIdle(context.Background(), 0)
+			return nil
+		}
+	}
+}
+
+// Flush uploads the buffered events of a session as a new part. Events added while uploading are kept
+// buffered, being uploaded again (replacing the part) afterwards.
+func (store *EventS3) Flush(ctx context.Context, sessionID string) error {
+	store.flushMu.Lock()
+	defer store.flushMu.Unlock()
+
+	var msgs [][]byte
+	store.mu.Lock()
+	first, blocks, err := store.bufferState(sessionID)
+	if err == nil && first > 0 {
+		err = store.buffer.Get(ctx, sessionID, func(b []byte, pos, size uint64) error {
+			msgs = append(msgs, append([]byte{}, b...))
+			return nil
+		})
+	}
+	store.mu.Unlock()
+	if err != nil || len(msgs) == 0 {
+		return err
+	}
+
+	var raw bytes.Buffer
+	for _, msg := range msgs {
+		raw.Write(msg)
+		raw.WriteByte('\n')
+	}
+	b, err := zstd.CompressLevel(nil, raw.Bytes(), 3)
+	if err != nil {
+		return err
+	}
+
+	name, err := sessionPathName(sessionID)
+	if err != nil {
+		return err
+	}
+
+	part := eventPart{First: first, Last: first + uint64(len(msgs)) - 1}
+	for _, block := range blocks {
+		if block.FromTs != 0 && (part.FromTs == 0 || block.FromTs < part.FromTs) {
+			part.FromTs = block.FromTs
+		}
+		if block.ToTs > part.ToTs {
+			part.ToTs = block.ToTs
+		}
+		if part.KeyframeTs == 0 && len(block.Keyframes) > 0 {
+			part.KeyframeTs = block.Keyframes[0].Timestamp
+		}
+	}
+	part.Key = path.Join(name, fmt.Sprintf("%020d-%020d_%d-%d_%d%s", part.First, part.Last, part.FromTs, part.ToTs, part.KeyframeTs, eventPartExt))
+	if err := store.objects.Put(ctx, part.Key, b, "application/zstd"); err != nil {
+		return err
+	}
+	eventBytes.WithLabelValues("raw").Add(float64(raw.Len()))
+	eventBytes.WithLabelValues("stored").Add(float64(len(b)))
+
+	// parts uploaded before with less events are replaced
+	parts, err := store.listParts(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if p.First == part.First && p.Last < part.Last {
+			if err := store.objects.Remove(ctx, p.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	current, blocks, err := store.bufferState(sessionID)
+	if err != nil || current != first || blocksLast(blocks) != uint64(len(msgs)) {
+		return err
+	}
+	if err := store.buffer.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+	store.removed++
+	seqPath, err := store.seqPath(sessionID)
+	if err != nil {
+		return err
+	}
+	return removeFile(seqPath)
+}
+
+// flushIdle uploads the sessions which had no events added for longer than idle
+func (store *EventS3) flushIdle(ctx context.Context, idle time.Duration) {
+	entries, err := os.ReadDir(store.buffer.store.Path)
+	if err != nil {
+		store.log.WithError(err).Error("failed to list buffered sessions")
+		return
+	}
+
+	for _, entry := range entries {
+		sessionID, ok := sessionFromPathName(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(store.buffer.store.Path, entry.Name(), eventIndexFile))
+		if err != nil || time.Since(info.ModTime()) < idle {
+			continue
+		}
+
+		if err := store.Flush(ctx, sessionID); err != nil {
+			store.log.WithError(err).WithField("session_id", sessionID).Error("failed to upload session events")
+		}
+	}
+}
+
+// view returns where the events of a session are. The buffer is checked first, so events uploaded
+// before it was created are in the listed parts. Parts with buffered events (which are being uploaded)
+// are ignored, with their events being read from the buffer.
+func (store *EventS3) view(ctx context.Context, sessionID string) (eventView, error) {
+	store.mu.Lock()
+	first, blocks, err := store.bufferState(sessionID)
+	store.mu.Unlock()
+	if err != nil {
+		return eventView{}, err
+	}
+
+	parts, err := store.parts(ctx, sessionID)
+	if err != nil {
+		return eventView{}, err
+	}
+	for i, part := range parts {
+		if first > 0 && part.First >= first {
+			parts = parts[:i]
+			break
+		}
+	}
+
+	return eventView{sessionID: sessionID, parts: parts, first: first, blocks: blocks}, nil
+}
+
+// withBuffer calls fn with the buffer directory of a view, returning errBufferFlushed if the buffer was
+// uploaded since. The buffer is locked meanwhile, so it isn't uploaded while being read.
+func (store *EventS3) withBuffer(v eventView, fn func(dir string) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	first, _, err := store.bufferState(v.sessionID)
+	if err != nil {
+		return err
+	}
+	if first != v.first {
+		return errBufferFlushed
+	}
+
+	dir, err := store.buffer.dir(v.sessionID)
+	if err != nil {
+		return err
+	}
+	return fn(dir)
+}
+
+// read returns the events of a view from one sequence to another (inclusive), downloading the parts
+// which have them
+func (store *EventS3) read(ctx context.Context, v eventView, from, to uint64) ([][]byte, error) {
+	msgs := make([][]byte, 0, to-from+1)
+	for _, part := range v.parts {
+		if part.Last < from || part.First > to {
+			continue
+		}
+
+		lines, err := store.readPart(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		for i, msg := range lines {
+			if seq := part.First + uint64(i); seq >= from && seq <= to {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+
+	if len(v.blocks) > 0 && to >= v.first {
+		rng := EventRange{FromSeq: 1, ToSeq: to - v.first + 1}
+		if from > v.first {
+			rng.FromSeq = from - v.first + 1
+		}
+
+		err := store.withBuffer(v, func(string) error {
+			_, err := store.buffer.GetRange(ctx, v.sessionID, rng, func(b []byte, pos, size uint64) error {
+				msgs = append(msgs, append([]byte{}, b...))
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if uint64(len(msgs)) != to-from+1 {
+		return nil, fmt.Errorf("events %d to %d of session %s are missing", from, to, v.sessionID)
+	}
+	return msgs, nil
+}
+
+// seqAt returns the sequence of the last event recorded at or before ts, or the first event recorded at
+// or after it if after is set. Parts are narrowed down by their timestamps (as EventFile blocks), with
+// only the matching ones being downloaded.
+func (store *EventS3) seqAt(ctx context.Context, v eventView, ts int64, after bool) (uint64, bool, error) {
+	buffered := func() (seq uint64, ok bool, err error) {
+		if len(v.blocks) == 0 {
+			return 0, false, nil
+		}
+		err = store.withBuffer(v, func(dir string) error {
+			seq, ok, err = store.buffer.seqAt(dir, v.blocks, ts, after)
+			return err
+		})
+		return v.first + seq - 1, ok, err
+	}
+
+	if !after {
+		if seq, ok, err := buffered(); err != nil || ok {
+			return seq, ok, err
+		}
+	}
+
+	for i := range v.parts {
+		part := v.parts[i]
+		if !after {
+			part = v.parts[len(v.parts)-1-i]
+		}
+		if part.ToTs == 0 || (after && part.ToTs < ts) || (!after && part.FromTs > ts) {
+			continue
+		}
+
+		msgs, err := store.readPart(ctx, part)
+		if err != nil {
+			return 0, false, err
+		}
+		if seq, ok := seqAt(msgs, ts, after); ok {
+			return part.First + seq - 1, true, nil
+		}
+	}
+
+	if after {
+		return buffered()
+	}
+	return 0, false, nil
+}
+
+// bufferState returns the sequence of the first buffered event of a session (0 if there are none),
+// along with its buffer index. It must be called while locked.
+func (store *EventS3) bufferState(sessionID string) (first uint64, blocks []eventBlock, err error) {
+	dir, err := store.buffer.dir(sessionID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	blocks, _, err = store.buffer.blocks(dir)
+	if err != nil || len(blocks) == 0 {
+		return 0, nil, err
+	}
+
+	b, err := os.ReadFile(dir + ".seq")
+	if err != nil {
+		return 0, nil, err
+	}
+	uploaded, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid uploaded sequence for session %s: %w", sessionID, err)
+	}
+	return uploaded + 1, blocks, nil
+}
+
+// parts returns the uploaded parts of a session, in order. Parts which are being replaced (see Flush)
+// are skipped.
+func (store *EventS3) parts(ctx context.Context, sessionID string) ([]eventPart, error) {
+	all, err := store.listParts(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []eventPart{}
+	for _, part := range all {
+		if len(parts) > 0 && parts[len(parts)-1].First == part.First {
+			parts[len(parts)-1] = part
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// listParts lists all uploaded parts of a session, sorted by their sequences
+func (store *EventS3) listParts(ctx context.Context, sessionID string) ([]eventPart, error) {
+	name, err := sessionPathName(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := store.objects.List(ctx, name+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []eventPart{}
+	for _, key := range keys {
+		part := eventPart{Key: key}
+		base := strings.TrimSuffix(path.Base(key), eventPartExt)
+		_, err := fmt.Sscanf(base, "%d-%d_%d-%d_%d", &part.First, &part.Last, &part.FromTs, &part.ToTs, &part.KeyframeTs)
+		if err != nil || part.First == 0 || part.Last < part.First {
+			continue
+		}
+		parts = append(parts, part)
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].First == parts[j].First {
+			return parts[i].Last < parts[j].Last
+		}
+		return parts[i].First < parts[j].First
+	})
+	return parts, nil
+}
+
+// readPart returns the events of an uploaded part
+func (store *EventS3) readPart(ctx context.Context, part eventPart) ([][]byte, error) {
+	b, err := store.objects.Get(ctx, part.Key)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = zstd.Decompress(nil, b); err != nil {
+		return nil, err
+	}
+
+	msgs := bytes.Split(bytes.TrimSuffix(b, []byte{'\n'}), []byte{'\n'})
+	if uint64(len(msgs)) != part.Last-part.First+1 {
+		return nil, fmt.Errorf("events part %s has %d events instead of %d", part.Key, len(msgs), part.Last-part.First+1)
+	}
+	return msgs, nil
+}
+
+func (store *EventS3) seqPath(sessionID string) (string, error) {
+	dir, err := store.buffer.dir(sessionID)
+	return dir + ".seq", err
+}
+
+// last returns the sequence of the last event of a view, being 0 if there are none
+func (v eventView) last() uint64 {
+	if len(v.blocks) > 0 {
+		return v.first + blocksLast(v.blocks) - 1
+	}
+	if len(v.parts) > 0 {
+		return v.parts[len(v.parts)-1].Last
+	}
+	return 0
+}
+
+// blocksLast returns the sequence of the last event of an EventFile index, being 0 if it is empty
+func blocksLast(blocks []eventBlock) uint64 {
+	if len(blocks) == 0 {
+		return 0
+	}
+	last := blocks[len(blocks)-1]
+	return last.Seq + last.Count - 1
+}
+
+// retryFlushed calls fn again if a buffer is uploaded while it is reading it, as its events are read
+// from the uploaded part afterwards
+func retryFlushed(fn func() error) (err error) {
+	for i := 0; i < maxReadAttempts; i++ {
+		if err = fn(); !errors.Is(err, errBufferFlushed) {
+			return err
+		}
+	}
+	return err
+}
+
+// seqAt returns the sequence of the last event recorded at or before ts, or the first event recorded at
+// or after it if after is set, assuming events are in order
+func seqAt(msgs [][]byte, ts int64, after bool) (uint64, bool) {
+	for i := range msgs {
+		k := i
+		if !after {
+			k = len(msgs) - 1 - i
+		}
+
+		events, err := rrweb.Parse(msgs[k])
+		if err != nil {
+			continue
+		}
+		if (after && events[0].Timestamp >= ts) || (!after && events[0].Timestamp <= ts) {
+			return uint64(k) + 1, true
+		}
+	}
+	return 0, false
+}
+
+func removeFile(name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
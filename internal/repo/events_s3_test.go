@@ -0,0 +1,391 @@
+package repo_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/op/logger"
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/storage/s3db"
+	"github.com/stretchr/testify/require"
+)
+
+// memObjects is an in-memory repo.ObjectStorage, which tracks the keys downloaded
+type memObjects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    []string
+}
+
+func (m *memObjects) Put(ctx context.Context, key string, b []byte, contentType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = append([]byte{}, b...)
+	return nil
+}
+
+func (m *memObjects) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	m.gets = append(m.gets, key)
+	return b, nil
+}
+
+func (m *memObjects) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []string{}
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memObjects) Remove(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// downloaded returns the keys downloaded since the last call
+func (m *memObjects) downloaded() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gets := m.gets
+	m.gets = nil
+	return gets
+}
+
+// blockingObjects is a memObjects which blocks the first upload (or listing) of a session until
+// released
+type blockingObjects struct {
+	*memObjects
+	op      string
+	prefix  string
+	once    sync.Once
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingObjects) wait(op, key string) {
+	if op == b.op && strings.HasPrefix(key, b.prefix) {
+		b.once.Do(func() {
+			close(b.blocked)
+			<-b.release
+		})
+	}
+}
+
+func (b *blockingObjects) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	b.wait("put", key)
+	return b.memObjects.Put(ctx, key, data, contentType)
+}
+
+func (b *blockingObjects) List(ctx context.Context, prefix string) ([]string, error) {
+	b.wait("list", prefix)
+	return b.memObjects.List(ctx, prefix)
+}
+
+// objectStorages returns the object storages used by tests: an in-memory one, along with an S3 one if
+// JORNADA_TEST_S3_DSN is set, such as s3://minioadmin:minioadmin@jornada?endpoint=localhost:9000&insecure=true
+// (the bucket must exist). Each test uses its own S3 prefix.
+func objectStorages(t *testing.T) map[string]func(t *testing.T) repo.ObjectStorage {
+	storages := map[string]func(t *testing.T) repo.ObjectStorage{
+		"memory": func(t *testing.T) repo.ObjectStorage {
+			return &memObjects{objects: map[string][]byte{}}
+		},
+	}
+
+	dsn := os.Getenv("JORNADA_TEST_S3_DSN")
+	if dsn == "" {
+		return storages
+	}
+
+	storages["s3"] = func(t *testing.T) repo.ObjectStorage {
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+		u.Path += fmt.Sprintf("/test/%s-%d", t.Name(), time.Now().UnixNano())
+
+		s, err := s3db.New(context.Background(), u.String())
+		require.NoError(t, err)
+		return s
+	}
+	return storages
+}
+
+func listKeys(t *testing.T, objects repo.ObjectStorage, sessionID string) []string {
+	keys, err := objects.List(context.Background(), sessionID+"/")
+	require.NoError(t, err)
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], sessionID+"/")
+	}
+	return keys
+}
+
+func TestEventS3(t *testing.T) {
+	ctx := context.Background()
+
+	// events 1 to 9, recorded every 100ms from 1000, with keyframes (meta + full snapshot) at 1100 and 1500.
+	// The second keyframe meta event (5) is uploaded in the first part.
+	types := []int{4, 2, 3, 3, 4, 2, 3, 3, 3}
+	events := []string{}
+	for i, typ := range types {
+		events = append(events, fmt.Sprintf(`{"type":%d,"data":{},"timestamp":%d}`, typ, 1000+i*100))
+	}
+
+	for name, newObjects := range objectStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			objects := newObjects(t)
+			buffer := t.TempDir()
+			store, err := repo.NewEventS3(objects, buffer, time.Minute, logger.New("error"))
+			require.NoError(t, err)
+
+			get := func(t *testing.T, rng repo.EventRange) ([]string, string) {
+				out := []string{}
+				next, err := store.GetRange(ctx, "a", rng, func(b []byte, pos, size uint64) error {
+					require.Equal(t, uint64(len(out)), pos)
+					out = append(out, string(b))
+					return nil
+				})
+				require.NoError(t, err)
+				return out, next
+			}
+			downloaded := func() []string {
+				if m, ok := objects.(*memObjects); ok {
+					return m.downloaded()
+				}
+				return nil
+			}
+
+			require.NoError(t, store.Add(ctx, "a", []byte(events[0]), []byte(events[1]), []byte(events[2])))
+			require.NoError(t, store.Add(ctx, "a", []byte(events[3]), []byte(events[4])))
+			all, _ := get(t, repo.EventRange{})
+			require.Equal(t, events[:5], all)
+			require.Empty(t, listKeys(t, objects, "a"))
+
+			// buffered events are uploaded as a part, being read from it afterwards
+			require.NoError(t, store.Flush(ctx, "a"))
+			require.Equal(t, []string{"00000000000000000001-00000000000000000005_1000-1400_1100.ndjson.zst"}, listKeys(t, objects, "a"))
+			require.NoDirExists(t, filepath.Join(buffer, "a"))
+			all, _ = get(t, repo.EventRange{})
+			require.Equal(t, events[:5], all)
+
+			// resumed sessions continue their sequences, being read from parts and buffer
+			require.NoError(t, store.Add(ctx, "a", []byte(events[5]), []byte(events[6])))
+			require.NoError(t, store.Add(ctx, "a", []byte(events[7]), []byte(events[8])))
+			all, _ = get(t, repo.EventRange{})
+			require.Equal(t, events, all)
+
+			kf, ok, err := store.KeyframeAt(ctx, "a", 1650)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, repo.Keyframe{Seq: 5, Timestamp: 1500}, kf)
+
+			kf, ok, err = store.KeyframeAt(ctx, "a", 1450)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, repo.Keyframe{Seq: 1, Timestamp: 1100}, kf)
+
+			page, next := get(t, repo.EventRange{FromTs: 1350, ToSeq: 8, Limit: 3})
+			require.Equal(t, events[4:7], page)
+			page, next = get(t, repo.EventRange{FromTs: 1350, ToSeq: 8, Limit: 3, Cursor: next})
+			require.Equal(t, events[7:8], page)
+			require.Empty(t, next)
+
+			require.NoError(t, store.Flush(ctx, "a"))
+			require.Equal(t, []string{
+				"00000000000000000001-00000000000000000005_1000-1400_1100.ndjson.zst",
+				"00000000000000000006-00000000000000000009_1500-1800_1500.ndjson.zst",
+			}, listKeys(t, objects, "a"))
+			all, _ = get(t, repo.EventRange{})
+			require.Equal(t, events, all)
+
+			// only the parts with the requested events (or keyframes) are downloaded
+			downloaded()
+			page, _ = get(t, repo.EventRange{FromTs: 1650, Limit: 2})
+			require.Equal(t, events[7:9], page)
+			kf, ok, err = store.KeyframeAt(ctx, "a", 1850)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, repo.Keyframe{Seq: 5, Timestamp: 1500}, kf)
+			page, _ = get(t, repo.EventRange{FromSeq: 2, ToSeq: 3})
+			require.Equal(t, events[1:3], page)
+			if name == "memory" {
+				require.Equal(t, []string{
+					"a/00000000000000000006-00000000000000000009_1500-1800_1500.ndjson.zst",
+					"a/00000000000000000006-00000000000000000009_1500-1800_1500.ndjson.zst",
+					"a/00000000000000000006-00000000000000000009_1500-1800_1500.ndjson.zst",
+					"a/00000000000000000001-00000000000000000005_1000-1400_1100.ndjson.zst",
+					"a/00000000000000000001-00000000000000000005_1000-1400_1100.ndjson.zst",
+				}, downloaded())
+			}
+
+			// session IDs which aren't safe in keys are encoded
+			require.NoError(t, store.Add(ctx, "b/../c", []byte(events[0])))
+			require.NoError(t, store.Flush(ctx, "b/../c"))
+			require.Len(t, listKeys(t, objects, "~Yi8uLi9j"), 1)
+
+			require.NoError(t, store.Add(ctx, "b", []byte(events[0])))
+			require.NoError(t, store.Delete(ctx, "a", "b", "b/../c"))
+			require.Empty(t, listKeys(t, objects, "a"))
+			require.Empty(t, listKeys(t, objects, "~Yi8uLi9j"))
+			all, _ = get(t, repo.EventRange{})
+			require.Empty(t, all)
+			require.NoDirExists(t, filepath.Join(buffer, "b"))
+		})
+	}
+}
+
+func TestEventS3Concurrency(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newObjects := range objectStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			objects := newObjects(t)
+			store, err := repo.NewEventS3(objects, t.TempDir(), time.Minute, logger.New("error"))
+			require.NoError(t, err)
+
+			// events added while uploading keep their sequences, without replacing uploaded parts
+			events := []string{}
+			for i := 0; i < 500; i++ {
+				events = append(events, fmt.Sprintf(`{"type":3,"data":{},"timestamp":%d}`, 1000+i))
+			}
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				for _, e := range events {
+					require.NoError(t, store.Add(ctx, "a", []byte(e)))
+				}
+			}()
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+							require.NoError(t, store.Flush(ctx, "a"))
+							_, err := store.GetRange(ctx, "a", repo.EventRange{FromTs: 1050, Limit: 5}, func(b []byte, pos, size uint64) error {
+								return nil
+							})
+							require.NoError(t, err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			require.NoError(t, store.Flush(ctx, "a"))
+
+			all := []string{}
+			require.NoError(t, store.Get(ctx, "a", func(b []byte, pos, size uint64) error {
+				all = append(all, string(b))
+				return nil
+			}))
+			require.Equal(t, events, all)
+		})
+	}
+}
+
+func TestEventS3Run(t *testing.T) {
+	for name, newObjects := range objectStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			objects := newObjects(t)
+
+			// idle times too short to be checked every idle/2 are checked less often
+			store, err := repo.NewEventS3(objects, t.TempDir(), time.Nanosecond, logger.New("error"))
+			require.NoError(t, err)
+
+			done := make(chan error)
+			go func() { done <- store.Run(ctx) }()
+
+			// idle sessions are uploaded
+			require.NoError(t, store.Add(ctx, "a", []byte(`{"type":4,"data":{},"timestamp":1000}`)))
+			require.Eventually(t, func() bool { return len(listKeys(t, objects, "a")) == 1 }, 5*time.Second, 50*time.Millisecond)
+			cancel()
+			require.NoError(t, <-done)
+
+			// live sessions are uploaded once the service stops
+			ctx, cancel = context.WithCancel(context.Background())
+			store, err = repo.NewEventS3(objects, t.TempDir(), time.Hour, logger.New("error"))
+			require.NoError(t, err)
+			go func() { done <- store.Run(ctx) }()
+
+			require.NoError(t, store.Add(ctx, "b", []byte(`{"type":4,"data":{},"timestamp":1000}`)))
+			require.Empty(t, listKeys(t, objects, "b"))
+			cancel()
+			require.NoError(t, <-done)
+			require.Equal(t, []string{"00000000000000000001-00000000000000000001_1000-1000_0.ndjson.zst"}, listKeys(t, objects, "b"))
+		})
+	}
+}
+
+func TestEventS3Blocking(t *testing.T) {
+	ctx := context.Background()
+	event := []byte(`{"type":4,"data":{},"timestamp":1000}`)
+	newStore := func(t *testing.T, op string) (*repo.EventS3, *blockingObjects) {
+		objects := &blockingObjects{memObjects: &memObjects{objects: map[string][]byte{}}, op: op, prefix: "a/", blocked: make(chan struct{}), release: make(chan struct{})}
+		store, err := repo.NewEventS3(objects, t.TempDir(), time.Minute, logger.New("error"))
+		require.NoError(t, err)
+		return store, objects
+	}
+
+	t.Run("delete while uploading", func(t *testing.T) {
+		store, objects := newStore(t, "put")
+		require.NoError(t, store.Add(ctx, "a", event))
+
+		// sessions deleted while being uploaded aren't uploaded again
+		flushed, deleted := make(chan error), make(chan error)
+		go func() { flushed <- store.Flush(ctx, "a") }()
+		<-objects.blocked
+		go func() { deleted <- store.Delete(ctx, "a") }()
+		time.Sleep(50 * time.Millisecond)
+		close(objects.release)
+		require.NoError(t, <-flushed)
+		require.NoError(t, <-deleted)
+		require.Empty(t, listKeys(t, objects, "a"))
+	})
+
+	t.Run("add while listing", func(t *testing.T) {
+		store, objects := newStore(t, "list")
+
+		// sessions are added while the parts of another one are being listed
+		added := make(chan error)
+		go func() { added <- store.Add(ctx, "a", event) }()
+		<-objects.blocked
+		require.NoError(t, store.Add(ctx, "b", event))
+		close(objects.release)
+		require.NoError(t, <-added)
+
+		for _, id := range []string{"a", "b"} {
+			all := 0
+			require.NoError(t, store.Get(ctx, id, func(b []byte, pos, size uint64) error {
+				all++
+				return nil
+			}))
+			require.Equal(t, 1, all)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// AttributeChange is a change of a session attribute (user.id or a meta.* path) made by a Save. Values
+// are formatted as search literals (see Meta.Flatten), being nil if the attribute was added or removed.
+type AttributeChange struct {
+	Attribute string    `json:"attribute"`
+	OldValue  *string   `json:"oldValue"`
+	NewValue  *string   `json:"newValue"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// GetAttributesHistory get the attribute changes of a session, in the order they happened
+func (store *SessionSQL) GetAttributesHistory(ctx context.Context, sessionID string) (out []AttributeChange, err error) {
+	query, params, err := sq.Select("attribute, old_value, new_value, changed_at").
+		From("session_attributes_history").
+		Where(sq.Eq{"session_id": sessionID}).
+		OrderBy("changed_at ASC", "id ASC").
+		PlaceholderFormat(store.db.Dialect.Placeholder()).
+		ToSql()
+	if err != nil {
+		return out, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res AttributeChange
+		var oldValue, newValue sql.NullString
+		if err := rows.Scan(&res.Attribute, &oldValue, &newValue, &res.ChangedAt); err != nil {
+			return out, err
+		}
+		if oldValue.Valid {
+			res.OldValue = &oldValue.String
+		}
+		if newValue.Valid {
+			res.NewValue = &newValue.String
+		}
+		out = append(out, res)
+	}
+
+	return out, rows.Err()
+}
+
+// attributeChanges returns the commands which record how a Save changes the attributes of an existing
+// session. Sessions being created have no changes, as their attributes are in the session itself.
+func (store *SessionSQL) attributeChanges(ctx context.Context, id, userID string, meta Meta, at time.Time) ([]sqldb.Cmd, error) {
+	query, params, err := sq.Select("user_id, meta").From("sessions").Where(sq.Eq{"id": id}).
+		PlaceholderFormat(store.db.Dialect.Placeholder()).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var prevUserID sql.NullString
+	var prevMeta []byte
+	err = store.db.QueryRowContext(ctx, query, params...).Scan(&prevUserID, &prevMeta)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var prev Meta
+	if err := json.Unmarshal(prevMeta, &prev); err != nil {
+		return nil, err
+	}
+
+	before, after := attributes(prev), attributes(meta)
+	if prevUserID.String != userID {
+		before["user.id"], after["user.id"] = quote(prevUserID.String), quote(userID)
+	}
+
+	keys := make([]string, 0, len(before)+len(after))
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	cmds := []sqldb.Cmd{}
+	for _, k := range keys {
+		oldValue, hadValue := before[k]
+		newValue, hasValue := after[k]
+		if hadValue && hasValue && oldValue == newValue {
+			continue
+		}
+
+		cmds = append(cmds, sqldb.Cmd{
+			SQL:    `INSERT INTO session_attributes_history (session_id, attribute, old_value, new_value, changed_at) VALUES ($1, $2, $3, $4, $5)`,
+			Params: []interface{}{id, k, nullString(oldValue, hadValue), nullString(newValue, hasValue), at},
+		})
+	}
+
+	return cmds, nil
+}
+
+// attributes returns the flattened meta paths as search fields (meta.*)
+func attributes(meta Meta) map[string]string {
+	out := map[string]string{}
+	for k, v := range meta.Flatten() {
+		out["meta."+k] = v
+	}
+	return out
+}
+
+// quote formats a string as a search literal
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func nullString(s string, valid bool) sql.NullString {
+	return sql.NullString{String: s, Valid: valid}
+}
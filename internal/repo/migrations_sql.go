@@ -0,0 +1,590 @@
+package repo
+
+import "github.com/brunoluiz/jornada/internal/storage/sqldb"
+
+// Migrations defines the SessionSQL schema history (see sqldb.Migrator). Once released, a migration
+// must not change: add a new one instead. Tables are created with IF NOT EXISTS, as they were
+// created at every boot before versioned migrations existed.
+//
+// Tables referenced by foreign keys must not be rebuilt (dropped and re-created) on SQLite, as dropping
+// them cascades deletes to the referencing tables.
+var Migrations = []sqldb.Migration{
+	{
+		Version: 1,
+		Name:    "create_sessions",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id TEXT PRIMARY KEY,
+					client_id TEXT,
+					user_id TEXT,
+					user_agent TEXT,
+					device TEXT,
+					meta JSON,
+					updated_at DATETIME
+				)`,
+				`CREATE TABLE IF NOT EXISTS users (
+					id TEXT PRIMARY KEY,
+					name TEXT,
+					email TEXT
+				)`,
+				`CREATE TABLE IF NOT EXISTS oses (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				`CREATE TABLE IF NOT EXISTS browsers (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				"CREATE INDEX IF NOT EXISTS sessions_client_id_idx ON sessions (client_id)",
+				"CREATE INDEX IF NOT EXISTS sessions_updated_at_idx ON sessions (updated_at)",
+				"CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)",
+				"CREATE INDEX IF NOT EXISTS browser_name_idx ON browsers (name)",
+				"CREATE INDEX IF NOT EXISTS browser_version_idx ON browsers (version)",
+				"CREATE INDEX IF NOT EXISTS oses_name_idx ON oses (name)",
+				"CREATE INDEX IF NOT EXISTS oses_version_idx ON oses (version)",
+			},
+			// jsonb is queried through #>> by search/v2
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id TEXT PRIMARY KEY,
+					client_id TEXT,
+					user_id TEXT,
+					user_agent TEXT,
+					device TEXT,
+					meta JSONB,
+					updated_at TIMESTAMPTZ
+				)`,
+				`CREATE TABLE IF NOT EXISTS users (
+					id TEXT PRIMARY KEY,
+					name TEXT,
+					email TEXT
+				)`,
+				`CREATE TABLE IF NOT EXISTS oses (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				`CREATE TABLE IF NOT EXISTS browsers (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				"CREATE INDEX IF NOT EXISTS sessions_client_id_idx ON sessions (client_id)",
+				"CREATE INDEX IF NOT EXISTS sessions_updated_at_idx ON sessions (updated_at)",
+				"CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)",
+				"CREATE INDEX IF NOT EXISTS browser_name_idx ON browsers (name)",
+				"CREATE INDEX IF NOT EXISTS browser_version_idx ON browsers (version)",
+				"CREATE INDEX IF NOT EXISTS oses_name_idx ON oses (name)",
+				"CREATE INDEX IF NOT EXISTS oses_version_idx ON oses (version)",
+			},
+			// TEXT columns can't be indexed (or have defaults) in MySQL, so VARCHAR is used instead
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS sessions (
+					id VARCHAR(191) PRIMARY KEY,
+					client_id VARCHAR(191),
+					user_id VARCHAR(191),
+					user_agent TEXT,
+					device VARCHAR(191),
+					meta JSON,
+					updated_at DATETIME(6),
+					INDEX sessions_client_id_idx (client_id),
+					INDEX sessions_updated_at_idx (updated_at),
+					INDEX sessions_user_id_idx (user_id)
+				)`,
+				`CREATE TABLE IF NOT EXISTS users (
+					id VARCHAR(191) PRIMARY KEY,
+					name TEXT,
+					email TEXT
+				)`,
+				`CREATE TABLE IF NOT EXISTS oses (
+					session_id VARCHAR(191) PRIMARY KEY,
+					name VARCHAR(191),
+					version VARCHAR(191),
+					INDEX oses_name_idx (name),
+					INDEX oses_version_idx (version)
+				)`,
+				`CREATE TABLE IF NOT EXISTS browsers (
+					session_id VARCHAR(191) PRIMARY KEY,
+					name VARCHAR(191),
+					version VARCHAR(191),
+					INDEX browser_name_idx (name),
+					INDEX browser_version_idx (version)
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE browsers", "DROP TABLE oses", "DROP TABLE users", "DROP TABLE sessions"},
+			sqldb.Postgres: {"DROP TABLE browsers", "DROP TABLE oses", "DROP TABLE users", "DROP TABLE sessions"},
+			sqldb.MySQL:    {"DROP TABLE browsers", "DROP TABLE oses", "DROP TABLE users", "DROP TABLE sessions"},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_session_texts",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE VIRTUAL TABLE IF NOT EXISTS session_texts USING fts5(
+					session_id UNINDEXED,
+					content
+				)`,
+			},
+			// full-text searches use a GIN index instead of FTS5
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS session_texts (
+					session_id TEXT,
+					content TEXT
+				)`,
+				"CREATE INDEX IF NOT EXISTS session_texts_session_id_idx ON session_texts (session_id)",
+				"CREATE INDEX IF NOT EXISTS session_texts_content_idx ON session_texts USING GIN (to_tsvector('simple', content))",
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS session_texts (
+					session_id VARCHAR(191),
+					content MEDIUMTEXT,
+					INDEX session_texts_session_id_idx (session_id)
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE session_texts"},
+			sqldb.Postgres: {"DROP TABLE session_texts"},
+			sqldb.MySQL:    {"DROP TABLE session_texts"},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_saved_searches",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS saved_searches (
+					id TEXT PRIMARY KEY,
+					name TEXT,
+					query TEXT,
+					created_at DATETIME
+				)`,
+			},
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS saved_searches (
+					id TEXT PRIMARY KEY,
+					name TEXT,
+					query TEXT,
+					created_at TIMESTAMPTZ
+				)`,
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS saved_searches (
+					id VARCHAR(191) PRIMARY KEY,
+					name TEXT,
+					query TEXT,
+					created_at DATETIME(6)
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE saved_searches"},
+			sqldb.Postgres: {"DROP TABLE saved_searches"},
+			sqldb.MySQL:    {"DROP TABLE saved_searches"},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_session_metrics",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				"ALTER TABLE sessions ADD COLUMN duration INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE sessions ADD COLUMN events_count INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE sessions ADD COLUMN pages_count INTEGER NOT NULL DEFAULT 0",
+				"ALTER TABLE sessions ADD COLUMN has_error BOOLEAN NOT NULL DEFAULT FALSE",
+				"ALTER TABLE sessions ADD COLUMN last_url TEXT NOT NULL DEFAULT ''",
+				"ALTER TABLE sessions ADD COLUMN first_event_ts INTEGER",
+				"ALTER TABLE sessions ADD COLUMN last_event_ts INTEGER",
+			},
+			// rrweb timestamps are unix milliseconds, which don't fit in INTEGER
+			sqldb.Postgres: {
+				`ALTER TABLE sessions
+					ADD COLUMN duration BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN events_count BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN pages_count BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN has_error BOOLEAN NOT NULL DEFAULT FALSE,
+					ADD COLUMN last_url TEXT NOT NULL DEFAULT '',
+					ADD COLUMN first_event_ts BIGINT,
+					ADD COLUMN last_event_ts BIGINT`,
+			},
+			sqldb.MySQL: {
+				`ALTER TABLE sessions
+					ADD COLUMN duration BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN events_count BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN pages_count BIGINT NOT NULL DEFAULT 0,
+					ADD COLUMN has_error BOOLEAN NOT NULL DEFAULT FALSE,
+					ADD COLUMN last_url VARCHAR(2048) NOT NULL DEFAULT '',
+					ADD COLUMN first_event_ts BIGINT,
+					ADD COLUMN last_event_ts BIGINT`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			// SQLite (3.34, bundled by go-sqlite3) can't drop columns, so the table is re-created
+			sqldb.SQLite: {
+				`CREATE TABLE sessions_down (
+					id TEXT PRIMARY KEY,
+					client_id TEXT,
+					user_id TEXT,
+					user_agent TEXT,
+					device TEXT,
+					meta JSON,
+					updated_at DATETIME
+				)`,
+				"INSERT INTO sessions_down SELECT id, client_id, user_id, user_agent, device, meta, updated_at FROM sessions",
+				"DROP TABLE sessions",
+				"ALTER TABLE sessions_down RENAME TO sessions",
+				"CREATE INDEX sessions_client_id_idx ON sessions (client_id)",
+				"CREATE INDEX sessions_updated_at_idx ON sessions (updated_at)",
+				"CREATE INDEX sessions_user_id_idx ON sessions (user_id)",
+			},
+			sqldb.Postgres: {
+				`ALTER TABLE sessions
+					DROP COLUMN duration,
+					DROP COLUMN events_count,
+					DROP COLUMN pages_count,
+					DROP COLUMN has_error,
+					DROP COLUMN last_url,
+					DROP COLUMN first_event_ts,
+					DROP COLUMN last_event_ts`,
+			},
+			sqldb.MySQL: {
+				`ALTER TABLE sessions
+					DROP COLUMN duration,
+					DROP COLUMN events_count,
+					DROP COLUMN pages_count,
+					DROP COLUMN has_error,
+					DROP COLUMN last_url,
+					DROP COLUMN first_event_ts,
+					DROP COLUMN last_event_ts`,
+			},
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_session_visits",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS session_visits (
+					session_id TEXT,
+					url TEXT,
+					path TEXT,
+					visited_at INTEGER
+				)`,
+				"CREATE INDEX IF NOT EXISTS session_visits_session_id_idx ON session_visits (session_id, visited_at)",
+				"CREATE INDEX IF NOT EXISTS session_visits_path_idx ON session_visits (path)",
+				"CREATE INDEX IF NOT EXISTS session_visits_url_idx ON session_visits (url)",
+			},
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS session_visits (
+					session_id TEXT,
+					url TEXT,
+					path TEXT,
+					visited_at BIGINT
+				)`,
+				"CREATE INDEX IF NOT EXISTS session_visits_session_id_idx ON session_visits (session_id, visited_at)",
+				"CREATE INDEX IF NOT EXISTS session_visits_path_idx ON session_visits (path)",
+				"CREATE INDEX IF NOT EXISTS session_visits_url_idx ON session_visits (url)",
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS session_visits (
+					session_id VARCHAR(191),
+					url VARCHAR(2048),
+					path VARCHAR(2048),
+					visited_at BIGINT,
+					INDEX session_visits_session_id_idx (session_id, visited_at),
+					INDEX session_visits_path_idx (path(191)),
+					INDEX session_visits_url_idx (url(191))
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE session_visits"},
+			sqldb.Postgres: {"DROP TABLE session_visits"},
+			sqldb.MySQL:    {"DROP TABLE session_visits"},
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_session_created_at",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				"ALTER TABLE sessions ADD COLUMN created_at DATETIME",
+				"UPDATE sessions SET created_at = updated_at",
+				"CREATE INDEX sessions_created_at_idx ON sessions (created_at)",
+			},
+			sqldb.Postgres: {
+				"ALTER TABLE sessions ADD COLUMN created_at TIMESTAMPTZ",
+				"UPDATE sessions SET created_at = updated_at",
+				"CREATE INDEX sessions_created_at_idx ON sessions (created_at)",
+			},
+			sqldb.MySQL: {
+				"ALTER TABLE sessions ADD COLUMN created_at DATETIME(6), ADD INDEX sessions_created_at_idx (created_at)",
+				"UPDATE sessions SET created_at = updated_at",
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				"DROP INDEX sessions_created_at_idx",
+				`CREATE TABLE sessions_down (
+					id TEXT PRIMARY KEY,
+					client_id TEXT,
+					user_id TEXT,
+					user_agent TEXT,
+					device TEXT,
+					meta JSON,
+					updated_at DATETIME,
+					duration INTEGER NOT NULL DEFAULT 0,
+					events_count INTEGER NOT NULL DEFAULT 0,
+					pages_count INTEGER NOT NULL DEFAULT 0,
+					has_error BOOLEAN NOT NULL DEFAULT FALSE,
+					last_url TEXT NOT NULL DEFAULT '',
+					first_event_ts INTEGER,
+					last_event_ts INTEGER
+				)`,
+				`INSERT INTO sessions_down SELECT id, client_id, user_id, user_agent, device, meta, updated_at,
+					duration, events_count, pages_count, has_error, last_url, first_event_ts, last_event_ts FROM sessions`,
+				"DROP TABLE sessions",
+				"ALTER TABLE sessions_down RENAME TO sessions",
+				"CREATE INDEX sessions_client_id_idx ON sessions (client_id)",
+				"CREATE INDEX sessions_updated_at_idx ON sessions (updated_at)",
+				"CREATE INDEX sessions_user_id_idx ON sessions (user_id)",
+			},
+			sqldb.Postgres: {"ALTER TABLE sessions DROP COLUMN created_at"},
+			sqldb.MySQL:    {"ALTER TABLE sessions DROP INDEX sessions_created_at_idx, DROP COLUMN created_at"},
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_session_foreign_keys",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				// SQLite can't add constraints to existing tables, so they are rebuilt (session_texts is
+				// a FTS5 table, which doesn't support them, so its rows are still deleted by SessionSQL.Delete)
+				`CREATE TABLE oses_fk (
+					session_id TEXT PRIMARY KEY REFERENCES sessions (id) ON DELETE CASCADE,
+					name TEXT,
+					version TEXT
+				)`,
+				"INSERT INTO oses_fk SELECT session_id, name, version FROM oses WHERE session_id IN (SELECT id FROM sessions)",
+				"DROP TABLE oses",
+				"ALTER TABLE oses_fk RENAME TO oses",
+				"CREATE INDEX oses_name_idx ON oses (name)",
+				"CREATE INDEX oses_version_idx ON oses (version)",
+				`CREATE TABLE browsers_fk (
+					session_id TEXT PRIMARY KEY REFERENCES sessions (id) ON DELETE CASCADE,
+					name TEXT,
+					version TEXT
+				)`,
+				"INSERT INTO browsers_fk SELECT session_id, name, version FROM browsers WHERE session_id IN (SELECT id FROM sessions)",
+				"DROP TABLE browsers",
+				"ALTER TABLE browsers_fk RENAME TO browsers",
+				"CREATE INDEX browser_name_idx ON browsers (name)",
+				"CREATE INDEX browser_version_idx ON browsers (version)",
+				`CREATE TABLE session_visits_fk (
+					session_id TEXT REFERENCES sessions (id) ON DELETE CASCADE,
+					url TEXT,
+					path TEXT,
+					visited_at INTEGER
+				)`,
+				"INSERT INTO session_visits_fk SELECT session_id, url, path, visited_at FROM session_visits WHERE session_id IN (SELECT id FROM sessions)",
+				"DROP TABLE session_visits",
+				"ALTER TABLE session_visits_fk RENAME TO session_visits",
+				"CREATE INDEX session_visits_session_id_idx ON session_visits (session_id, visited_at)",
+				"CREATE INDEX session_visits_path_idx ON session_visits (path)",
+				"CREATE INDEX session_visits_url_idx ON session_visits (url)",
+			},
+			sqldb.Postgres: {
+				"DELETE FROM oses WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE oses ADD CONSTRAINT oses_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM browsers WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE browsers ADD CONSTRAINT browsers_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM session_visits WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE session_visits ADD CONSTRAINT session_visits_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM session_texts WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE session_texts ADD CONSTRAINT session_texts_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+			},
+			sqldb.MySQL: {
+				"DELETE FROM oses WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE oses ADD CONSTRAINT oses_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM browsers WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE browsers ADD CONSTRAINT browsers_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM session_visits WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE session_visits ADD CONSTRAINT session_visits_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+				"DELETE FROM session_texts WHERE session_id NOT IN (SELECT id FROM sessions)",
+				"ALTER TABLE session_texts ADD CONSTRAINT session_texts_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE",
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE oses_nofk (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				"INSERT INTO oses_nofk SELECT session_id, name, version FROM oses",
+				"DROP TABLE oses",
+				"ALTER TABLE oses_nofk RENAME TO oses",
+				"CREATE INDEX oses_name_idx ON oses (name)",
+				"CREATE INDEX oses_version_idx ON oses (version)",
+				`CREATE TABLE browsers_nofk (
+					session_id TEXT PRIMARY KEY,
+					name TEXT,
+					version TEXT
+				)`,
+				"INSERT INTO browsers_nofk SELECT session_id, name, version FROM browsers",
+				"DROP TABLE browsers",
+				"ALTER TABLE browsers_nofk RENAME TO browsers",
+				"CREATE INDEX browser_name_idx ON browsers (name)",
+				"CREATE INDEX browser_version_idx ON browsers (version)",
+				`CREATE TABLE session_visits_nofk (
+					session_id TEXT,
+					url TEXT,
+					path TEXT,
+					visited_at INTEGER
+				)`,
+				"INSERT INTO session_visits_nofk SELECT session_id, url, path, visited_at FROM session_visits",
+				"DROP TABLE session_visits",
+				"ALTER TABLE session_visits_nofk RENAME TO session_visits",
+				"CREATE INDEX session_visits_session_id_idx ON session_visits (session_id, visited_at)",
+				"CREATE INDEX session_visits_path_idx ON session_visits (path)",
+				"CREATE INDEX session_visits_url_idx ON session_visits (url)",
+			},
+			sqldb.Postgres: {
+				"ALTER TABLE oses DROP CONSTRAINT oses_session_id_fk",
+				"ALTER TABLE browsers DROP CONSTRAINT browsers_session_id_fk",
+				"ALTER TABLE session_visits DROP CONSTRAINT session_visits_session_id_fk",
+				"ALTER TABLE session_texts DROP CONSTRAINT session_texts_session_id_fk",
+			},
+			sqldb.MySQL: {
+				"ALTER TABLE oses DROP FOREIGN KEY oses_session_id_fk",
+				"ALTER TABLE browsers DROP FOREIGN KEY browsers_session_id_fk",
+				"ALTER TABLE session_visits DROP FOREIGN KEY session_visits_session_id_fk",
+				"ALTER TABLE session_texts DROP FOREIGN KEY session_texts_session_id_fk",
+			},
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_user_aliases",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS user_aliases (
+					alias_id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+					created_at DATETIME
+				)`,
+				"CREATE INDEX IF NOT EXISTS user_aliases_user_id_idx ON user_aliases (user_id)",
+			},
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS user_aliases (
+					alias_id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+					created_at TIMESTAMPTZ
+				)`,
+				"CREATE INDEX IF NOT EXISTS user_aliases_user_id_idx ON user_aliases (user_id)",
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS user_aliases (
+					alias_id VARCHAR(191) PRIMARY KEY,
+					user_id VARCHAR(191) NOT NULL,
+					created_at DATETIME(6),
+					INDEX user_aliases_user_id_idx (user_id),
+					CONSTRAINT user_aliases_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE user_aliases"},
+			sqldb.Postgres: {"DROP TABLE user_aliases"},
+			sqldb.MySQL:    {"DROP TABLE user_aliases"},
+		},
+	},
+	{
+		Version: 9,
+		Name:    "create_session_attributes_history",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS session_attributes_history (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+					attribute TEXT NOT NULL,
+					old_value TEXT,
+					new_value TEXT,
+					changed_at DATETIME NOT NULL
+				)`,
+				"CREATE INDEX IF NOT EXISTS session_attributes_history_session_id_idx ON session_attributes_history (session_id, changed_at)",
+			},
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS session_attributes_history (
+					id BIGSERIAL PRIMARY KEY,
+					session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+					attribute TEXT NOT NULL,
+					old_value TEXT,
+					new_value TEXT,
+					changed_at TIMESTAMPTZ NOT NULL
+				)`,
+				"CREATE INDEX IF NOT EXISTS session_attributes_history_session_id_idx ON session_attributes_history (session_id, changed_at)",
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS session_attributes_history (
+					id BIGINT AUTO_INCREMENT PRIMARY KEY,
+					session_id VARCHAR(191) NOT NULL,
+					attribute VARCHAR(191) NOT NULL,
+					old_value TEXT,
+					new_value TEXT,
+					changed_at DATETIME(6) NOT NULL,
+					INDEX session_attributes_history_session_id_idx (session_id, changed_at),
+					CONSTRAINT session_attributes_history_session_id_fk FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+				)`,
+			},
+		},
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE session_attributes_history"},
+			sqldb.Postgres: {"DROP TABLE session_attributes_history"},
+			sqldb.MySQL:    {"DROP TABLE session_attributes_history"},
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_promoted_meta_keys",
+		Up: map[sqldb.Dialect][]string{
+			sqldb.SQLite: {
+				`CREATE TABLE IF NOT EXISTS promoted_meta_keys (
+					column_name TEXT PRIMARY KEY,
+					meta_key TEXT NOT NULL,
+					type TEXT NOT NULL,
+					promoted_at DATETIME NOT NULL
+				)`,
+			},
+			sqldb.Postgres: {
+				`CREATE TABLE IF NOT EXISTS promoted_meta_keys (
+					column_name TEXT PRIMARY KEY,
+					meta_key TEXT NOT NULL,
+					type TEXT NOT NULL,
+					promoted_at TIMESTAMPTZ NOT NULL
+				)`,
+			},
+			sqldb.MySQL: {
+				`CREATE TABLE IF NOT EXISTS promoted_meta_keys (
+					column_name VARCHAR(191) PRIMARY KEY,
+					meta_key VARCHAR(191) NOT NULL,
+					type VARCHAR(16) NOT NULL,
+					promoted_at DATETIME(6) NOT NULL
+				)`,
+			},
+		},
+		// indexes (and SQLite generated columns) of promoted keys are managed by MetaKeyPromoter, being
+		// kept when the registry is dropped: promoting the keys again reuses them
+		Down: map[sqldb.Dialect][]string{
+			sqldb.SQLite:   {"DROP TABLE promoted_meta_keys"},
+			sqldb.Postgres: {"DROP TABLE promoted_meta_keys"},
+			sqldb.MySQL:    {"DROP TABLE promoted_meta_keys"},
+		},
+	},
+}
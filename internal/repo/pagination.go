@@ -0,0 +1,283 @@
+package repo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Page size limits used by List
+const (
+	DefaultPageSize uint64 = 10
+	MaxPageSize     uint64 = 100
+)
+
+// MaxEventsPageSize limits how many events are returned by EventBadgerV2.GetRange
+const MaxEventsPageSize uint64 = 10000
+
+// Errors returned by List when its options are invalid
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// SortField defines which field sessions are sorted by
+type SortField string
+
+// Available sort fields, named after their search fields
+const (
+	SortCreatedAt   SortField = "created_at"
+	SortUpdatedAt   SortField = "updated_at"
+	SortDuration    SortField = "duration"
+	SortEventsCount SortField = "events.count"
+)
+
+var sortColumns = map[SortField]string{
+	SortCreatedAt:   "s.created_at",
+	SortUpdatedAt:   "s.updated_at",
+	SortDuration:    "s.duration",
+	SortEventsCount: "s.events_count",
+}
+
+// SortOrder defines if sessions are sorted in ascending or descending order
+type SortOrder string
+
+// Available sort orders
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+type (
+	// ListOpts configure which page List returns. Empty fields use the defaults: sorted by
+	// updated_at, in descending order, with DefaultPageSize sessions per page.
+	ListOpts struct {
+		Sort   SortField
+		Order  SortOrder
+		Limit  uint64
+		Cursor string
+	}
+
+	// SessionPage is a page of sessions returned by List. Next and Prev are cursors for the
+	// neighbouring pages, empty when there are none.
+	SessionPage struct {
+		Sessions []Session `json:"sessions"`
+		Total    uint64    `json:"total"`
+		Next     string    `json:"next,omitempty"`
+		Prev     string    `json:"prev,omitempty"`
+	}
+
+	// EventRange bounds the events returned by EventBadgerV2.GetRange. Bounds are inclusive, with zero
+	// values being unbounded. Timestamp bounds (rrweb timestamps, in unix milliseconds) are resolved to
+	// sequences through the timestamps index, assuming events are recorded in order. Without a limit, all
+	// events in the range are returned; Cursor is the position of a page from a previous call.
+	EventRange struct {
+		FromSeq uint64
+		ToSeq   uint64
+		FromTs  int64
+		ToTs    int64
+		Limit   uint64
+		Cursor  string
+	}
+
+	// eventCursor is the sequence of the first event of a page
+	eventCursor struct {
+		Seq uint64 `json:"seq"`
+	}
+
+	// cursor is the position of a session in a listing (sort value + id). It is encoded as
+	// base64 JSON, so clients should treat it as opaque.
+	cursor struct {
+		Sort   SortField       `json:"s"`
+		Value  json.RawMessage `json:"v"`
+		ID     string          `json:"id"`
+		Before bool            `json:"b,omitempty"`
+	}
+)
+
+func (opts ListOpts) withDefaults() (ListOpts, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortUpdatedAt
+	}
+	if _, ok := sortColumns[opts.Sort]; !ok {
+		return opts, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, opts.Sort)
+	}
+
+	switch opts.Order {
+	case "":
+		opts.Order = SortDesc
+	case SortAsc, SortDesc:
+	default:
+		return opts, fmt.Errorf("%w: unknown order %q", ErrInvalidSort, opts.Order)
+	}
+
+	if opts.Limit == 0 {
+		opts.Limit = DefaultPageSize
+	}
+	if opts.Limit > MaxPageSize {
+		opts.Limit = MaxPageSize
+	}
+
+	return opts, nil
+}
+
+func (rng EventRange) withDefaults() (EventRange, error) {
+	if rng.Limit > MaxEventsPageSize {
+		rng.Limit = MaxEventsPageSize
+	}
+	if rng.FromSeq == 0 {
+		rng.FromSeq = 1
+	}
+
+	if rng.Cursor != "" {
+		seq, err := decodeEventCursor(rng.Cursor)
+		if err != nil {
+			return rng, err
+		}
+		if seq > rng.FromSeq {
+			rng.FromSeq = seq
+		}
+	}
+
+	return rng, nil
+}
+
+// bounds resolves the sequences to be read from a session whose last event is last, returning the first
+// sequence and how many events are read (none if size is 0), with a cursor to the next page if there are
+// more events than the limit. seqAt resolves timestamps to the last event recorded at or before them, or
+// to the first recorded at or after them if after is set.
+func (rng EventRange) bounds(last uint64, seqAt func(ts int64, after bool) (uint64, bool, error)) (from, size uint64, next string, err error) {
+	from, to := rng.FromSeq, last
+	if rng.ToSeq > 0 && rng.ToSeq < to {
+		to = rng.ToSeq
+	}
+	if rng.FromTs != 0 {
+		seq, ok, err := seqAt(rng.FromTs, true)
+		if err != nil || !ok {
+			return 0, 0, "", err
+		}
+		if seq > from {
+			from = seq
+		}
+	}
+	if rng.ToTs != 0 {
+		seq, ok, err := seqAt(rng.ToTs, false)
+		if err != nil || !ok {
+			return 0, 0, "", err
+		}
+		if seq < to {
+			to = seq
+		}
+	}
+	if from > to {
+		return 0, 0, "", nil
+	}
+
+	size = to - from + 1
+	if rng.Limit > 0 && size > rng.Limit {
+		size = rng.Limit
+		if next, err = encodeEventCursor(from + size); err != nil {
+			return 0, 0, "", err
+		}
+	}
+	return from, size, next, nil
+}
+
+func encodeEventCursor(seq uint64) (string, error) {
+	b, err := json.Marshal(eventCursor{Seq: seq})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeEventCursor(in string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(in)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	var c eventCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.Seq == 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return c.Seq, nil
+}
+
+// sortValue returns the value of the sort field for a session
+func sortValue(field SortField, s Session) interface{} {
+	switch field {
+	case SortDuration:
+		return s.Duration
+	case SortEventsCount:
+		return s.EventsCount
+	case SortCreatedAt:
+		return s.CreatedAt
+	}
+	return s.UpdatedAt
+}
+
+func encodeCursor(field SortField, s Session, before bool) (string, error) {
+	value, err := json.Marshal(sortValue(field, s))
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(cursor{Sort: field, Value: value, ID: s.ID, Before: before})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor decodes a cursor, returning it with its sort value parsed
+func decodeCursor(field SortField, in string) (c cursor, value interface{}, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(in)
+	if err != nil {
+		return c, nil, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, nil, ErrInvalidCursor
+	}
+
+	if c.Sort != field {
+		return c, nil, fmt.Errorf("%w: cursor is sorted by %q instead of %q", ErrInvalidCursor, c.Sort, field)
+	}
+
+	switch field {
+	case SortCreatedAt, SortUpdatedAt:
+		var t time.Time
+		err = json.Unmarshal(c.Value, &t)
+		value = t
+	default:
+		var n uint64
+		err = json.Unmarshal(c.Value, &n)
+		value = n
+	}
+	if err != nil {
+		return c, nil, ErrInvalidCursor
+	}
+
+	return c, value, nil
+}
+
+// keyset filters sessions after (or before) the cursor position, using the session ID as tiebreaker
+func keyset(column string, desc bool, value interface{}, id string) sq.Sqlizer {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+
+	return sq.Or{
+		sq.Expr(column+" "+op+" ?", value),
+		sq.And{sq.Expr(column+" = ?", value), sq.Expr("s.id "+op+" ?", id)},
+	}
+}
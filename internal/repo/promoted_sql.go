@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// MetaKeyPromoter indexes promoted meta keys (see search.PromotedKey), so searches over them don't need
+// to scan all sessions. SQLite indexes generated columns, which the search compiler queries instead of
+// the meta JSON, while PostgreSQL uses expression indexes. Keys are recorded in promoted_meta_keys, so
+// keys which are no longer configured are demoted (their indexes dropped).
+type MetaKeyPromoter struct {
+	db   *sqldb.DB
+	keys []search.PromotedKey
+}
+
+// NewMetaKeyPromoter returns a MetaKeyPromoter for the configured keys
+func NewMetaKeyPromoter(db *sqldb.DB, keys []search.PromotedKey) *MetaKeyPromoter {
+	return &MetaKeyPromoter{db: db, keys: keys}
+}
+
+// Pending returns keys which weren't promoted yet and promoted keys which are no longer configured
+func (p *MetaKeyPromoter) Pending(ctx context.Context) (promote, demote []search.PromotedKey, err error) {
+	if len(p.keys) > 0 && p.db.Dialect == sqldb.MySQL {
+		return nil, nil, fmt.Errorf("promoted meta keys are not supported for %s", p.db.Dialect)
+	}
+
+	promoted, err := p.promoted(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configured := map[string]bool{}
+	for _, k := range p.keys {
+		configured[k.Column()] = true
+		if _, ok := promoted[k.Column()]; !ok {
+			promote = append(promote, k)
+		}
+	}
+	for column, k := range promoted {
+		if !configured[column] {
+			demote = append(demote, k)
+		}
+	}
+
+	return promote, demote, nil
+}
+
+// Check returns sqldb.ErrSchemaOutdated if the promoted keys don't match the configured ones
+func (p *MetaKeyPromoter) Check(ctx context.Context) error {
+	promote, demote, err := p.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(promote) > 0 || len(demote) > 0 {
+		return fmt.Errorf("%w: %d meta keys to promote and %d to demote", sqldb.ErrSchemaOutdated, len(promote), len(demote))
+	}
+	return nil
+}
+
+// Up promotes configured keys and demotes the ones which are no longer configured, returning them
+func (p *MetaKeyPromoter) Up(ctx context.Context) (promoted, demoted []search.PromotedKey, err error) {
+	promote, demote, err := p.Pending(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, k := range promote {
+		cmds, err := p.promote(ctx, k)
+		if err == nil {
+			err = sqldb.Exec(ctx, p.db, cmds...)
+		}
+		if err != nil {
+			return promoted, demoted, fmt.Errorf("promote meta key %s: %w", k, err)
+		}
+		promoted = append(promoted, k)
+	}
+
+	for _, k := range demote {
+		err := sqldb.Exec(ctx, p.db, sqldb.Cmd{
+			SQL: "DROP INDEX IF EXISTS " + indexName(k),
+		}, sqldb.Cmd{
+			SQL:    "DELETE FROM promoted_meta_keys WHERE column_name = $1",
+			Params: []interface{}{k.Column()},
+		})
+		if err != nil {
+			return promoted, demoted, fmt.Errorf("demote meta key %s: %w", k, err)
+		}
+		demoted = append(demoted, k)
+	}
+
+	return promoted, demoted, nil
+}
+
+// promote returns the commands which index a key. SQLite generated columns are kept when keys are
+// demoted, as columns can't be dropped before SQLite 3.35 (virtual columns aren't stored anyway), so
+// they are only added if they don't exist yet.
+func (p *MetaKeyPromoter) promote(ctx context.Context, k search.PromotedKey) ([]sqldb.Cmd, error) {
+	expr, err := k.Expr(p.db.Dialect, "meta")
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := []sqldb.Cmd{}
+	switch p.db.Dialect {
+	case sqldb.SQLite:
+		var exists int
+		err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_xinfo('sessions') WHERE name = ?", k.Column()).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			cmds = append(cmds, sqldb.Cmd{SQL: "ALTER TABLE sessions ADD COLUMN " + k.Column() + " GENERATED ALWAYS AS (" + expr + ") VIRTUAL"})
+		}
+		cmds = append(cmds, sqldb.Cmd{SQL: "CREATE INDEX IF NOT EXISTS " + indexName(k) + " ON sessions (" + k.Column() + ")"})
+	case sqldb.Postgres:
+		cmds = append(cmds, sqldb.Cmd{SQL: "CREATE INDEX IF NOT EXISTS " + indexName(k) + " ON sessions ((" + expr + "))"})
+	default:
+		return nil, fmt.Errorf("promoted meta keys are not supported for %s", p.db.Dialect)
+	}
+
+	return append(cmds, sqldb.Cmd{
+		SQL:    "INSERT INTO promoted_meta_keys (column_name, meta_key, type, promoted_at) VALUES ($1, $2, $3, $4)",
+		Params: []interface{}{k.Column(), k.Key, k.Type, time.Now()},
+	}), nil
+}
+
+// promoted returns the promoted keys by their column names
+func (p *MetaKeyPromoter) promoted(ctx context.Context) (map[string]search.PromotedKey, error) {
+	rows, err := p.db.QueryContext(ctx, "SELECT column_name, meta_key, type FROM promoted_meta_keys")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]search.PromotedKey{}
+	for rows.Next() {
+		var column, key, typ string
+		if err := rows.Scan(&column, &key, &typ); err != nil {
+			return nil, err
+		}
+
+		keys, err := search.ParsePromotedKeys([]string{key + ":" + typ})
+		if err != nil {
+			return nil, err
+		}
+		out[column] = keys[0]
+	}
+
+	return out, rows.Err()
+}
+
+func indexName(k search.PromotedKey) string {
+	return "sessions_" + k.Column() + "_idx"
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// SavedSearch a named search query, which can be re-used or shared between users
+type SavedSearch struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SaveSearch save a search, creating an ID if it doesn't have one
+func (store *SessionSQL) SaveSearch(ctx context.Context, in SavedSearch) (SavedSearch, error) {
+	if in.ID == "" {
+		in.ID = newULID()
+	}
+	if in.CreatedAt.IsZero() {
+		in.CreatedAt = time.Now()
+	}
+
+	err := sqldb.Exec(ctx, store.db, sqldb.Cmd{
+		SQL: `INSERT INTO saved_searches (id, name, query, created_at)
+			VALUES ($1, $2, $3, $4) ` + store.db.Dialect.Upsert("id", "name", "query"),
+		Params: []interface{}{in.ID, in.Name, in.Query, in.CreatedAt},
+	})
+	return in, err
+}
+
+// GetSavedSearches get all saved searches, ordered by name
+func (store *SessionSQL) GetSavedSearches(ctx context.Context) (out []SavedSearch, err error) {
+	return store.getSavedSearches(ctx, sq.Select("id, name, query, created_at").From("saved_searches").OrderBy("name ASC"))
+}
+
+// GetSavedSearchByID get saved search by id, returning sql.ErrNoRows if it doesn't exist
+func (store *SessionSQL) GetSavedSearchByID(ctx context.Context, id string) (SavedSearch, error) {
+	res, err := store.getSavedSearches(ctx, sq.Select("id, name, query, created_at").From("saved_searches").Where(sq.Eq{"id": id}))
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if len(res) == 0 {
+		return SavedSearch{}, sql.ErrNoRows
+	}
+	return res[0], nil
+}
+
+// DeleteSavedSearch delete a saved search
+func (store *SessionSQL) DeleteSavedSearch(ctx context.Context, id string) error {
+	return sqldb.Exec(ctx, store.db, sqldb.Cmd{
+		SQL:    `DELETE FROM saved_searches WHERE id = $1`,
+		Params: []interface{}{id},
+	})
+}
+
+func (store *SessionSQL) getSavedSearches(ctx context.Context, q sq.SelectBuilder) (out []SavedSearch, err error) {
+	sql, params, err := q.PlaceholderFormat(store.db.Dialect.Placeholder()).ToSql()
+	if err != nil {
+		return out, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, sql, params...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res SavedSearch
+		if err := rows.Scan(&res.ID, &res.Name, &res.Query, &res.CreatedAt); err != nil {
+			return out, err
+		}
+		out = append(out, res)
+	}
+
+	return out, rows.Err()
+}
@@ -1,13 +1,16 @@
 package repo
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"math/rand"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/brunoluiz/jornada/internal/rrweb"
 	"github.com/brunoluiz/jornada/internal/storage/sqldb"
 	"github.com/oklog/ulid"
 	"github.com/sirupsen/logrus"
@@ -16,7 +19,7 @@ import (
 type (
 	// SessionSQL defines a session SQL repository
 	SessionSQL struct {
-		db  *sql.DB
+		db  *sqldb.DB
 		log *logrus.Logger
 	}
 
@@ -41,83 +44,100 @@ type (
 
 	// Session session model, mostly with data from user and browser used
 	Session struct {
-		ID        string            `json:"id"`
-		ClientID  string            `json:"clientId"`
-		UserAgent string            `json:"userAgent"`
-		OS        OS                `json:"os"`
-		Browser   Browser           `json:"browser"`
-		Device    string            `json:"device"`
-		Version   string            `json:"version"`
-		Meta      map[string]string `json:"meta"`
-		User      User              `json:"user"`
-		UpdatedAt time.Time         `json:"updatedAt"`
+		ID        string    `json:"id"`
+		ClientID  string    `json:"clientId"`
+		UserAgent string    `json:"userAgent"`
+		OS        OS        `json:"os"`
+		Browser   Browser   `json:"browser"`
+		Device    string    `json:"device"`
+		Version   string    `json:"version"`
+		Meta      Meta      `json:"meta"`
+		User      User      `json:"user"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+
+		// Metrics computed from recorded events (see AddMetrics). StartedAt and EndedAt are the first
+		// and last event times, being nil until events are recorded. Duration is in seconds.
+		StartedAt   *time.Time `json:"startedAt"`
+		EndedAt     *time.Time `json:"endedAt"`
+		Duration    uint64     `json:"duration"`
+		EventsCount uint64     `json:"eventsCount"`
+		PagesCount  uint64     `json:"pagesCount"`
+		HasError    bool       `json:"hasError"`
+		LastURL     string     `json:"lastUrl"`
 	}
 
+	// Meta is the session metadata set by the client, which may have any JSON value (including nested
+	// objects). Numbers are decoded as json.Number, so they keep their precision.
+	Meta map[string]interface{}
+
 	// GetOpt configure Get query builder
 	GetOpt func(b *sq.SelectBuilder)
 )
 
+// UnmarshalJSON implements json.Unmarshaler, decoding numbers as json.Number
+func (m *Meta) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var out map[string]interface{}
+	if err := dec.Decode(&out); err != nil {
+		return err
+	}
+
+	*m = out
+	return nil
+}
+
+// Flatten returns meta values keyed by their paths (nested keys are joined by dots), formatted as
+// search literals: such as {"plan": "'pro'", "cart.total": "10"} for meta.plan and meta.cart.total.
+// Arrays are formatted as JSON, as they can't be searched.
+func (m Meta) Flatten() map[string]string {
+	out := map[string]string{}
+	flattenMeta(out, "", m)
+	return out
+}
+
+func flattenMeta(out map[string]string, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenMeta(out, prefix+k+".", v)
+		case Meta:
+			flattenMeta(out, prefix+k+".", v)
+		case string:
+			out[prefix+k] = quote(v)
+		case nil:
+			out[prefix+k] = "null"
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			out[prefix+k] = string(b)
+		}
+	}
+}
+
 // GetOrCreateID get or create an ID (based on ULID)
 func (s *Session) GetOrCreateID() string {
 	if s.ID != "" {
 		return s.ID
 	}
 
+	return newULID()
+}
+
+func newULID() string {
 	t := time.Now()
 	//nolint
 	return ulid.MustNew(ulid.Timestamp(t), ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)).String()
 }
 
-// NewSessionSQL cretes a session repository using SQL, running the migrations on init.
-// If a new migration is added, ensure that something previously created doesn't exist through
-// IF NOT EXISTS operations.
-func NewSessionSQL(ctx context.Context, db *sql.DB, log *logrus.Logger) (*SessionSQL, error) {
-	cmds := []sqldb.Cmd{
-		{
-			SQL: `CREATE TABLE IF NOT EXISTS sessions (
-				id TEXT PRIMARY KEY,
-				client_id TEXT,
-				user_id TEXT,
-				user_agent TEXT,
-				device TEXT,
-				meta JSON,
-				updated_at DATETIME
-			)`,
-		},
-		{
-			SQL: `CREATE TABLE IF NOT EXISTS users (
-				id TEXT PRIMARY KEY,
-				name TEXT,
-				email TEXT
-			)`,
-		},
-		{
-			SQL: `CREATE TABLE IF NOT EXISTS oses (
-				session_id TEXT PRIMARY KEY,
-				name TEXT,
-				version TEXT
-			)`,
-		},
-		{
-			SQL: `CREATE TABLE IF NOT EXISTS browsers (
-				session_id TEXT PRIMARY KEY,
-				name TEXT,
-				version TEXT
-			)`,
-		},
-		{SQL: "CREATE INDEX IF NOT EXISTS sessions_client_id_idx ON sessions (client_id)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS sessions_updated_at_idx ON sessions (updated_at)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS browser_name_idx ON browsers (name)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS browser_version_idx ON browsers (version)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS oses_name_idx ON oses (name)"},
-		{SQL: "CREATE INDEX IF NOT EXISTS oses_version_idx ON oses (version)"},
-	}
-	if err := sqldb.Exec(ctx, db, cmds...); err != nil {
-		return nil, err
-	}
-
-	return &SessionSQL{db, log}, nil
+// NewSessionSQL cretes a session repository using SQL. The database schema must be up to date
+// (see Migrations).
+func NewSessionSQL(db *sqldb.DB, log *logrus.Logger) *SessionSQL {
+	return &SessionSQL{db, log}
 }
 
 // Save save resource
@@ -127,42 +147,98 @@ func (store *SessionSQL) Save(ctx context.Context, in Session) error {
 		return err
 	}
 
+	// sessions of aliased users are saved with the user they were linked to (see Alias), whose details
+	// are kept, as the anonymous user details are outdated
+	userID, aliased, err := store.resolveUserID(ctx, in.User.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	history, err := store.attributeChanges(ctx, in.ID, userID, in.Meta, now)
+	if err != nil {
+		return err
+	}
+
+	dialect := store.db.Dialect
 	cmds := []sqldb.Cmd{{
-		SQL: `INSERT INTO sessions (id, client_id, user_id, user_agent, device, updated_at, meta)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-			ON CONFLICT (id) DO UPDATE SET
-				user_id = EXCLUDED.user_id,
-				updated_at = EXCLUDED.updated_at,
-				meta = EXCLUDED.meta
-			`,
-		Params: []interface{}{in.ID, in.ClientID, in.User.ID, in.UserAgent, in.Device, time.Now(), meta},
-	}, {
-		SQL: `INSERT INTO users (id, name, email)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (id) DO UPDATE SET
-				name = EXCLUDED.name,
-				email = EXCLUDED.email`,
-		Params: []interface{}{in.User.ID, in.User.Name, in.User.Email},
+		SQL: `INSERT INTO sessions (id, client_id, user_id, user_agent, device, created_at, updated_at, meta)
+			VALUES ($1, $2, $3, $4, $5, $6, $6, $7) ` + dialect.Upsert("id", "user_id", "updated_at", "meta"),
+		Params: []interface{}{in.ID, in.ClientID, userID, in.UserAgent, in.Device, now, string(meta)},
 	}, {
-		SQL: `INSERT INTO browsers (session_id, name, version) VALUES ($1, $2, $3)
-				ON CONFLICT (session_id) DO UPDATE SET
-				name = EXCLUDED.name,
-				version = EXCLUDED.version
-			`,
+		SQL:    `INSERT INTO browsers (session_id, name, version) VALUES ($1, $2, $3) ` + dialect.Upsert("session_id", "name", "version"),
 		Params: []interface{}{in.ID, in.Browser.Name, in.Browser.Version},
 	}, {
-		SQL: `INSERT INTO oses (session_id, name, version) VALUES ($1, $2, $3)
-				ON CONFLICT (session_id) DO UPDATE SET
-				name = EXCLUDED.name,
-				version = EXCLUDED.version
-			`,
+		SQL:    `INSERT INTO oses (session_id, name, version) VALUES ($1, $2, $3) ` + dialect.Upsert("session_id", "name", "version"),
 		Params: []interface{}{in.ID, in.OS.Name, in.OS.Version},
-	},
+	}}
+	if !aliased {
+		cmds = append(cmds, sqldb.Cmd{
+			SQL:    `INSERT INTO users (id, name, email) VALUES ($1, $2, $3) ` + dialect.Upsert("id", "name", "email"),
+			Params: []interface{}{in.User.ID, in.User.Name, in.User.Email},
+		})
+	}
+
+	return sqldb.Exec(ctx, store.db, append(cmds, history...)...)
+}
+
+// AddTexts indexes texts seen or typed during a session, making them available for full-text searches
+func (store *SessionSQL) AddTexts(ctx context.Context, sessionID string, texts ...string) error {
+	if len(texts) == 0 {
+		return nil
+	}
+
+	return sqldb.Exec(ctx, store.db, sqldb.Cmd{
+		SQL:    `INSERT INTO session_texts (session_id, content) VALUES ($1, $2)`,
+		Params: []interface{}{sessionID, strings.Join(texts, "\n")},
+	})
+}
+
+// AddVisits saves pages visited during a session, making them available for URL and path searches
+func (store *SessionSQL) AddVisits(ctx context.Context, sessionID string, visits ...rrweb.Visit) error {
+	cmds := make([]sqldb.Cmd, 0, len(visits))
+	for _, visit := range visits {
+		cmds = append(cmds, sqldb.Cmd{
+			SQL:    `INSERT INTO session_visits (session_id, url, path, visited_at) VALUES ($1, $2, $3, $4)`,
+			Params: []interface{}{sessionID, visit.URL, visit.Path, visit.Timestamp},
+		})
 	}
 
 	return sqldb.Exec(ctx, store.db, cmds...)
 }
 
+// AddMetrics accumulates metrics from a batch of events into the session. Timestamps are rrweb
+// timestamps (unix milliseconds) and duration is stored in seconds.
+func (store *SessionSQL) AddMetrics(ctx context.Context, sessionID string, m rrweb.Metrics) error {
+	// NULL values keep what is already stored
+	var lastURL, firstTS, lastTS interface{}
+	if m.LastURL != "" {
+		lastURL = m.LastURL
+	}
+	if m.FirstTimestamp > 0 {
+		firstTS, lastTS = m.FirstTimestamp, m.LastTimestamp
+	}
+
+	// MySQL's / returns decimals, which would be rounded instead of truncated
+	div := "/"
+	if store.db.Dialect == sqldb.MySQL {
+		div = "DIV"
+	}
+
+	return sqldb.Exec(ctx, store.db, sqldb.Cmd{
+		SQL: `UPDATE sessions SET
+				events_count = events_count + $1,
+				pages_count = pages_count + $2,
+				has_error = (has_error OR $3),
+				last_url = COALESCE($4, last_url),
+				first_event_ts = COALESCE(first_event_ts, $5),
+				last_event_ts = COALESCE($6, last_event_ts),
+				duration = COALESCE(($6 - COALESCE(first_event_ts, $5)) ` + div + ` 1000, duration)
+			WHERE id = $7`,
+		Params: []interface{}{m.EventsCount, m.PagesCount, m.HasError, lastURL, firstTS, lastTS, sessionID},
+	})
+}
+
 // GetByID get resource by id
 func (store *SessionSQL) GetByID(ctx context.Context, id string) (out Session, err error) {
 	res, err := store.Get(ctx, WithSearchFilter("s.id = ?", []interface{}{id}))
@@ -172,39 +248,128 @@ func (store *SessionSQL) GetByID(ctx context.Context, id string) (out Session, e
 	return res[0], nil
 }
 
-// WithSearchFilter filter query using search/v1 query output
+// WithSearchFilter filter query using search/v2 query output
 func WithSearchFilter(cond string, params []interface{}) func(b *sq.SelectBuilder) {
 	return func(b *sq.SelectBuilder) {
 		*b = b.Where(cond, params...)
 	}
 }
 
-// WithPagination filter query with offset and limit
-func WithPagination(offset uint64, limit uint64) func(b *sq.SelectBuilder) {
+// WithInactiveSince filter query with sessions which were neither updated nor received events after a time
+func WithInactiveSince(t time.Time) func(b *sq.SelectBuilder) {
 	return func(b *sq.SelectBuilder) {
-		*b = b.Offset(offset).Limit(limit)
+		*b = b.Where("s.updated_at <= ? AND (s.last_event_ts IS NULL OR s.last_event_ts <= ?)", t, unixMillis(t))
 	}
 }
 
-// WithUpdatedAtUntil filter query with updated_at <= time.Time
-func WithUpdatedAtUntil(updatedAt time.Time) func(b *sq.SelectBuilder) {
-	return func(b *sq.SelectBuilder) {
-		*b = b.Where("updated_at <= ?", updatedAt)
+// sessionColumns are the columns scanned by scanSession. Columns from joined tables may be NULL, as sessions
+// are listed even if their details were partially saved.
+const sessionColumns = `s.id, s.client_id, s.user_agent, s.device, COALESCE(os.name, ''), COALESCE(os.version, ''),
+	COALESCE(browser.name, ''), COALESCE(browser.version, ''), s.created_at, s.updated_at, s.meta,
+	COALESCE(u.id, ''), COALESCE(u.name, ''), COALESCE(u.email, ''), s.first_event_ts, s.last_event_ts, s.duration, s.events_count, s.pages_count, s.has_error, s.last_url`
+
+// selectSessions returns the base query for session lookups, joining all session related tables
+func (store *SessionSQL) selectSessions(columns string) sq.SelectBuilder {
+	return sq.Select(columns).
+		From("sessions s").
+		LeftJoin("users u ON s.user_id = u.id").
+		LeftJoin("browsers browser ON s.id = browser.session_id").
+		LeftJoin("oses os ON s.id = os.session_id").
+		PlaceholderFormat(store.db.Dialect.Placeholder())
+}
+
+// Count count resources matching the filters
+func (store *SessionSQL) Count(ctx context.Context, opts ...GetOpt) (count uint64, err error) {
+	q := store.selectSessions("COUNT(*)")
+	for _, opt := range opts {
+		opt(&q)
 	}
+
+	sql, params, err := q.ToSql()
+	if err != nil {
+		return count, err
+	}
+
+	err = store.db.QueryRowContext(ctx, sql, params...).Scan(&count)
+	return count, err
 }
 
 // Get get all available resources
 func (store *SessionSQL) Get(ctx context.Context, opts ...GetOpt) (out []Session, err error) {
-	q := sq.Select(`s.id, s.client_id, s.user_agent, device, os.name, os.version, browser.name, browser.version, s.updated_at, s.meta, user.id, user.name, user.email`).
-		From("sessions s").
-		Join("users user ON s.user_id = user.id").
-		Join("browsers browser ON s.id = browser.session_id").
-		Join("oses os ON s.id = os.session_id").
-		OrderBy("s.updated_at DESC")
+	q := store.selectSessions(sessionColumns).OrderBy("s.updated_at DESC")
 	for _, opt := range opts {
 		opt(&q)
 	}
 
+	return store.query(ctx, q)
+}
+
+// List returns a page of sessions matching the filters, using keyset pagination: cursors point to
+// the first or last session of a page, so pages are stable even if new sessions are recorded.
+func (store *SessionSQL) List(ctx context.Context, opts ListOpts, filters ...GetOpt) (page SessionPage, err error) {
+	opts, err = opts.withDefaults()
+	if err != nil {
+		return page, err
+	}
+
+	q := store.selectSessions(sessionColumns)
+	for _, opt := range filters {
+		opt(&q)
+	}
+
+	// sessions before the cursor are fetched in reverse order, being reversed back afterwards
+	column := sortColumns[opts.Sort]
+	desc := opts.Order == SortDesc
+	var before bool
+	if opts.Cursor != "" {
+		c, value, err := decodeCursor(opts.Sort, opts.Cursor)
+		if err != nil {
+			return page, err
+		}
+		before = c.Before
+		q = q.Where(keyset(column, desc != before, value, c.ID))
+	}
+
+	dir := "ASC"
+	if desc != before {
+		dir = "DESC"
+	}
+	q = q.OrderBy(column+" "+dir, "s.id "+dir).Limit(opts.Limit + 1)
+
+	sessions, err := store.query(ctx, q)
+	if err != nil {
+		return page, err
+	}
+
+	more := uint64(len(sessions)) > opts.Limit
+	if more {
+		sessions = sessions[:opts.Limit]
+	}
+	if before {
+		for i, j := 0, len(sessions)-1; i < j; i, j = i+1, j-1 {
+			sessions[i], sessions[j] = sessions[j], sessions[i]
+		}
+	}
+	page.Sessions = sessions
+
+	if len(sessions) > 0 {
+		if more || before {
+			if page.Next, err = encodeCursor(opts.Sort, sessions[len(sessions)-1], false); err != nil {
+				return page, err
+			}
+		}
+		if (more && before) || (!before && opts.Cursor != "") {
+			if page.Prev, err = encodeCursor(opts.Sort, sessions[0], true); err != nil {
+				return page, err
+			}
+		}
+	}
+
+	page.Total, err = store.Count(ctx, filters...)
+	return page, err
+}
+
+func (store *SessionSQL) query(ctx context.Context, q sq.SelectBuilder) (out []Session, err error) {
 	sql, params, err := q.ToSql()
 	store.log.WithFields(logrus.Fields{
 		"sql":    sql,
@@ -232,20 +397,45 @@ func (store *SessionSQL) Get(ctx context.Context, opts ...GetOpt) (out []Session
 	return out, nil
 }
 
-// Delete delete a specified set of IDs
+// Delete delete a specified set of IDs. Rows referencing sessions are deleted through foreign key
+// cascades, besides session_texts, which can't have foreign keys on SQLite (FTS5).
 func (store *SessionSQL) Delete(ctx context.Context, ids ...string) error {
-	q := sq.Delete("sessions").Where(sq.Eq{"id": ids})
-	sql, params, err := q.ToSql()
-	if err != nil {
-		return err
+	tables := []struct{ name, column string }{
+		{"session_texts", "session_id"},
+		{"sessions", "id"},
+	}
+
+	cmds := make([]sqldb.Cmd, 0, len(tables))
+	for _, table := range tables {
+		q := sq.Delete(table.name).Where(sq.Eq{table.column: ids}).PlaceholderFormat(store.db.Dialect.Placeholder())
+		sql, params, err := q.ToSql()
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, sqldb.Cmd{SQL: sql, Params: params})
 	}
 
-	_, err = store.db.ExecContext(ctx, sql, params...)
-	return err
+	return sqldb.Exec(ctx, store.db, cmds...)
+}
+
+// DeleteOrphanUsers deletes users which don't have sessions anymore, returning how many were deleted.
+// Users with aliases are kept (even if they were identified before having sessions), so later sessions
+// of their aliases are still linked to them. Attribute history rows are deleted along with their
+// sessions, so they don't reference users without sessions.
+func (store *SessionSQL) DeleteOrphanUsers(ctx context.Context) (int64, error) {
+	res, err := store.db.ExecContext(ctx, `DELETE FROM users
+		WHERE id NOT IN (SELECT user_id FROM sessions WHERE user_id IS NOT NULL)
+		AND id NOT IN (SELECT user_id FROM user_aliases)`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
 func scanSession(rs sq.RowScanner) (Session, error) {
 	var meta []byte
+	var createdAt sql.NullTime
+	var startedAt, endedAt sql.NullInt64
 	var session Session
 	err := rs.Scan(
 		&session.ID,
@@ -256,11 +446,19 @@ func scanSession(rs sq.RowScanner) (Session, error) {
 		&session.OS.Version,
 		&session.Browser.Name,
 		&session.Browser.Version,
+		&createdAt,
 		&session.UpdatedAt,
 		&meta,
 		&session.User.ID,
 		&session.User.Name,
 		&session.User.Email,
+		&startedAt,
+		&endedAt,
+		&session.Duration,
+		&session.EventsCount,
+		&session.PagesCount,
+		&session.HasError,
+		&session.LastURL,
 	)
 	if err != nil {
 		return session, err
@@ -270,5 +468,24 @@ func scanSession(rs sq.RowScanner) (Session, error) {
 		return session, err
 	}
 
+	session.CreatedAt = createdAt.Time
+	if startedAt.Valid {
+		t := fromUnixMillis(startedAt.Int64)
+		session.StartedAt = &t
+	}
+	if endedAt.Valid {
+		t := fromUnixMillis(endedAt.Int64)
+		session.EndedAt = &t
+	}
+
 	return session, nil
 }
+
+// unixMillis converts a time to unix milliseconds, as used by rrweb timestamps
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
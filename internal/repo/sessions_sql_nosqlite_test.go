@@ -0,0 +1,13 @@
+//go:build !fts5
+// +build !fts5
+
+package repo_test
+
+import "testing"
+
+func init() {
+	testDatabases["sqlite"] = func(t *testing.T) string {
+		t.Skip("SQLite tests need the json1 and fts5 tags: go test --tags 'json1 fts5' ./...")
+		return ""
+	}
+}
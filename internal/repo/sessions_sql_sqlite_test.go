@@ -0,0 +1,15 @@
+//go:build fts5
+// +build fts5
+
+package repo_test
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	testDatabases["sqlite"] = func(t *testing.T) string {
+		return "sqlite://" + filepath.Join(t.TempDir(), "jornada.db")
+	}
+}
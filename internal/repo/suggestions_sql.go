@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// ValueCount a distinct value of a session field and how many sessions have it. Type is the JSON
+// type of meta values (string, number or bool), being string for other fields, as numbers and
+// booleans are searched without quotes.
+type ValueCount struct {
+	Value string `json:"value"`
+	Type  string `json:"type"`
+	Count uint64 `json:"count"`
+}
+
+// GetCommonValues returns the most common values for browser.name, os.name, device and every meta key
+// seen so far, keyed by their search field names (such as `browser.name` or `meta.foo`, with nested
+// meta keys joined by dots, such as `meta.cart.total`). Only the most recently updated sessions are
+// counted, so meta objects aren't walked for all sessions. Values are ordered by count and limited per
+// field, which is done by the database.
+func (store *SessionSQL) GetCommonValues(ctx context.Context, limit, sessions int) (map[string][]ValueCount, error) {
+	metaValues, err := store.metaValuesQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	query, params, err := store.db.Dialect.Bind(`WITH RECURSIVE recent_sessions AS (
+			SELECT id, device, meta FROM sessions ORDER BY updated_at DESC LIMIT $2
+		),
+		`+metaValues+`,
+		field_values (field, value, kind, n) AS (
+			SELECT 'browser.name', b.name, 'string', COUNT(*) FROM recent_sessions s JOIN browsers b ON b.session_id = s.id GROUP BY b.name
+			UNION ALL SELECT 'os.name', o.name, 'string', COUNT(*) FROM recent_sessions s JOIN oses o ON o.session_id = s.id GROUP BY o.name
+			UNION ALL SELECT 'device', device, 'string', COUNT(*) FROM recent_sessions GROUP BY device
+			UNION ALL SELECT field, value, kind, n FROM meta_values
+		)
+		SELECT field, value, kind, n FROM (
+			SELECT field, value, kind, n, ROW_NUMBER() OVER (PARTITION BY field ORDER BY n DESC, value) AS r
+			FROM field_values WHERE value IS NOT NULL
+		) ranked WHERE r <= $1 ORDER BY field, r`, []interface{}{limit, sessions})
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string][]ValueCount{}
+	for rows.Next() {
+		var field, value, kind string
+		var count uint64
+		if err := rows.Scan(&field, &value, &kind, &count); err != nil {
+			return nil, err
+		}
+		out[field] = append(out[field], ValueCount{Value: value, Type: kind, Count: count})
+	}
+
+	return out, rows.Err()
+}
+
+// metaValuesQuery returns the common table expression (meta_values) which counts the recent sessions
+// having each meta key/value pair, along with the value JSON type. Nested objects are walked, with their
+// keys joined by dots; arrays and nulls are skipped, as they can't be searched.
+func (store *SessionSQL) metaValuesQuery() (string, error) {
+	switch store.db.Dialect {
+	case sqldb.SQLite:
+		// json_tree returns booleans as 1 and 0, so they are converted back
+		return `meta_values (field, value, kind, n) AS (
+			SELECT field, value, kind, COUNT(*) FROM (
+				SELECT 'meta.' || substr(j.fullkey, 3) AS field,
+					CASE j.type WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE j.value END AS value,
+					CASE WHEN j.type IN ('true', 'false') THEN 'bool' WHEN j.type IN ('integer', 'real') THEN 'number' ELSE 'string' END AS kind
+				FROM recent_sessions s, json_tree(s.meta) j
+				WHERE j.type NOT IN ('object', 'array', 'null') AND instr(j.fullkey, '[') = 0
+			) m GROUP BY field, value, kind
+		)`, nil
+	case sqldb.Postgres:
+		return `meta_tree (k, v) AS (
+			SELECT j.key, j.value FROM recent_sessions s, jsonb_each(s.meta) j
+			UNION ALL
+			SELECT m.k || '.' || j.key, j.value
+			FROM meta_tree m, jsonb_each(CASE WHEN jsonb_typeof(m.v) = 'object' THEN m.v ELSE '{}'::jsonb END) j
+		),
+		meta_values (field, value, kind, n) AS (
+			SELECT 'meta.' || k, v #>> '{}',
+				CASE jsonb_typeof(v) WHEN 'boolean' THEN 'bool' WHEN 'number' THEN 'number' ELSE 'string' END, COUNT(*)
+			FROM meta_tree WHERE jsonb_typeof(v) NOT IN ('object', 'array', 'null') GROUP BY k, v #>> '{}', jsonb_typeof(v)
+		)`, nil
+	case sqldb.MySQL:
+		// requires JSON_TABLE (MySQL 8 or MariaDB 10.6)
+		return `meta_tree (k, v) AS (
+			SELECT CAST(j.k AS CHAR(1000)), JSON_EXTRACT(s.meta, CONCAT('$."', j.k, '"'))
+			FROM recent_sessions s, JSON_TABLE(JSON_KEYS(s.meta), '$[*]' COLUMNS (k VARCHAR(191) PATH '$')) j
+			UNION ALL
+			SELECT CONCAT(m.k, '.', j.k), JSON_EXTRACT(m.v, CONCAT('$."', j.k, '"'))
+			FROM meta_tree m, JSON_TABLE(JSON_KEYS(m.v), '$[*]' COLUMNS (k VARCHAR(191) PATH '$')) j
+		),
+		meta_values (field, value, kind, n) AS (
+			SELECT CONCAT('meta.', k), JSON_UNQUOTE(v),
+				CASE WHEN JSON_TYPE(v) = 'BOOLEAN' THEN 'bool' WHEN JSON_TYPE(v) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') THEN 'number' ELSE 'string' END,
+				COUNT(*)
+			FROM meta_tree WHERE JSON_TYPE(v) NOT IN ('OBJECT', 'ARRAY', 'NULL') GROUP BY k, JSON_UNQUOTE(v), JSON_TYPE(v)
+		)`, nil
+	}
+
+	return "", fmt.Errorf("suggestions are not supported for %s", store.db.Dialect)
+}
@@ -0,0 +1,264 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// Errors returned by Alias
+var (
+	// ErrInvalidAlias is returned when the anonymous or user IDs are missing
+	ErrInvalidAlias = errors.New("invalid alias")
+	// ErrAliasConflict is returned when the anonymous ID was identified already (it has a name, an
+	// e-mail or aliases, or is linked to another user), so its sessions can't be taken over
+	ErrAliasConflict = errors.New("anonymous id was identified already")
+)
+
+// UserSummary is a user with totals across all their sessions. FirstSeen and LastSeen consider both
+// when sessions were saved and their recorded events.
+type UserSummary struct {
+	User
+	SessionsCount uint64    `json:"sessionsCount"`
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastSeen      time.Time `json:"lastSeen"`
+}
+
+// GetUsers get users with sessions, most recently updated first. If query is set, only users whose ID,
+// name or e-mail contain it are returned (% and _ are matched literally).
+func (store *SessionSQL) GetUsers(ctx context.Context, query string, limit uint64) ([]UserSummary, error) {
+	if limit == 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	q := store.selectUsers().Limit(limit)
+	if query != "" {
+		like, escape := "%"+search.EscapeLike(query)+"%", search.LikeEscape(store.db.Dialect)
+		q = q.Where(sq.Or{
+			sq.Expr("u.id LIKE ?"+escape, like),
+			sq.Expr("u.name LIKE ?"+escape, like),
+			sq.Expr("u.email LIKE ?"+escape, like),
+		})
+	}
+
+	return store.queryUsers(ctx, q)
+}
+
+// GetUser get a user with their totals, returning sql.ErrNoRows if they don't have sessions. Aliases
+// are resolved, so the returned user ID may differ from the requested one.
+func (store *SessionSQL) GetUser(ctx context.Context, id string) (UserSummary, error) {
+	id, _, err := store.resolveUserID(ctx, id)
+	if err != nil {
+		return UserSummary{}, err
+	}
+
+	res, err := store.queryUsers(ctx, store.selectUsers().Where(sq.Eq{"u.id": id}))
+	if err != nil {
+		return UserSummary{}, err
+	}
+	if len(res) == 0 {
+		return UserSummary{}, sql.ErrNoRows
+	}
+	return res[0], nil
+}
+
+// Alias links an anonymous user ID (such as one generated before the user logged in) to a known user,
+// saving the user details (empty ones don't replace known details). Sessions of the anonymous ID are
+// moved to the user, and later sessions saved with the anonymous ID are saved with the user ID instead.
+// If the user is an alias itself, the anonymous ID is linked to the user it resolves to. The resolved
+// user is returned.
+// Only IDs which were never identified can be linked (see ErrAliasConflict).
+func (store *SessionSQL) Alias(ctx context.Context, anonymousID string, user User) (User, error) {
+	if anonymousID == "" || user.ID == "" {
+		return user, ErrInvalidAlias
+	}
+
+	id, _, err := store.resolveUserID(ctx, user.ID)
+	if err != nil {
+		return user, err
+	}
+	user.ID = id
+	if anonymousID == user.ID {
+		return user, nil
+	}
+
+	aliasOf, ok, err := store.resolveUserID(ctx, anonymousID)
+	if err != nil {
+		return user, err
+	}
+	if ok && aliasOf == user.ID {
+		return user, nil
+	}
+	if ok {
+		return user, ErrAliasConflict
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return user, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	// the alias is inserted before checking the anonymous ID, so concurrent aliases of the same ID conflict
+	// on its key instead of both passing the check. Empty details don't replace the ones known already.
+	now := time.Now()
+	dialect := store.db.Dialect
+	err = sqldb.ExecTx(ctx, tx, dialect, sqldb.Cmd{
+		SQL:    `INSERT INTO users (id, name, email) VALUES ($1, $2, $3) ` + dialect.UpsertNonEmpty("users", "id", "name", "email"),
+		Params: []interface{}{user.ID, user.Name, user.Email},
+	}, sqldb.Cmd{
+		SQL:    `INSERT INTO user_aliases (alias_id, user_id, created_at) VALUES ($1, $2, $3)`,
+		Params: []interface{}{anonymousID, user.ID, now},
+	})
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			return user, err
+		}
+		return user, store.aliasError(ctx, anonymousID, user.ID, err)
+	}
+
+	identified, err := store.isIdentified(ctx, tx, anonymousID)
+	if err != nil {
+		return user, err
+	}
+	if identified {
+		return user, ErrAliasConflict
+	}
+
+	err = sqldb.ExecTx(ctx, tx, dialect, sqldb.Cmd{
+		SQL: `INSERT INTO session_attributes_history (session_id, attribute, old_value, new_value, changed_at)
+			SELECT id, 'user.id', $2, $3, $4 FROM sessions WHERE user_id = $1`,
+		Params: []interface{}{anonymousID, quote(anonymousID), quote(user.ID), now},
+	}, sqldb.Cmd{
+		SQL:    `UPDATE sessions SET user_id = $2 WHERE user_id = $1`,
+		Params: []interface{}{anonymousID, user.ID},
+	}, sqldb.Cmd{
+		SQL:    `DELETE FROM users WHERE id = $1`,
+		Params: []interface{}{anonymousID},
+	})
+	if err != nil {
+		return user, err
+	}
+	return user, tx.Commit()
+}
+
+// aliasError returns the error of an alias which couldn't be inserted: if the anonymous ID was linked
+// meanwhile (by a concurrent Alias), it is a conflict, unless it was linked to the same user
+func (store *SessionSQL) aliasError(ctx context.Context, anonymousID, userID string, err error) error {
+	aliasOf, ok, resolveErr := store.resolveUserID(ctx, anonymousID)
+	switch {
+	case resolveErr != nil || !ok:
+		return err
+	case aliasOf == userID:
+		return nil
+	default:
+		return ErrAliasConflict
+	}
+}
+
+// resolveUserID returns the user an ID is an alias of, or the ID itself if it isn't an alias
+func (store *SessionSQL) resolveUserID(ctx context.Context, id string) (string, bool, error) {
+	query, params, err := sq.Select("user_id").From("user_aliases").Where(sq.Eq{"alias_id": id}).
+		PlaceholderFormat(store.db.Dialect.Placeholder()).ToSql()
+	if err != nil {
+		return id, false, err
+	}
+
+	var userID string
+	err = store.db.QueryRowContext(ctx, query, params...).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, false, nil
+	}
+	if err != nil {
+		return id, false, err
+	}
+	return userID, true, nil
+}
+
+// isIdentified returns if a user ID was identified: it has a name or an e-mail, aliases, or sessions
+// were moved to it (by an alias or a later Save). Identified IDs can't be aliased, so their aliases
+// never have to be moved.
+func (store *SessionSQL) isIdentified(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	query, params, err := store.db.Dialect.Bind(`SELECT
+		(SELECT COUNT(*) FROM users WHERE id = $1 AND (COALESCE(name, '') <> '' OR COALESCE(email, '') <> '')) +
+		(SELECT COUNT(*) FROM user_aliases WHERE user_id = $1) +
+		(SELECT COUNT(*) FROM session_attributes_history WHERE attribute = 'user.id' AND new_value = $2)`,
+		[]interface{}{id, quote(id)})
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := tx.QueryRowContext(ctx, query, params...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// WithUserID filter query with sessions of a user
+func WithUserID(id string) func(b *sq.SelectBuilder) {
+	return func(b *sq.SelectBuilder) {
+		*b = b.Where("s.user_id = ?", id)
+	}
+}
+
+// selectUsers returns the base query for user lookups. Anonymous sessions (anonymise mode) are saved
+// with an empty user ID, so they are ignored.
+func (store *SessionSQL) selectUsers() sq.SelectBuilder {
+	return sq.Select(`u.id, COALESCE(u.name, ''), COALESCE(u.email, ''), COUNT(s.id),
+		MIN(s.created_at), MAX(s.updated_at), MIN(s.first_event_ts), MAX(s.last_event_ts)`).
+		From("users u").
+		Join("sessions s ON s.user_id = u.id").
+		Where("u.id <> ''").
+		GroupBy("u.id", "u.name", "u.email").
+		OrderBy("MAX(s.updated_at) DESC", "u.id").
+		PlaceholderFormat(store.db.Dialect.Placeholder())
+}
+
+func (store *SessionSQL) queryUsers(ctx context.Context, q sq.SelectBuilder) (out []UserSummary, err error) {
+	query, params, err := q.ToSql()
+	if err != nil {
+		return out, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res UserSummary
+		var createdAt, updatedAt sqldb.NullTime
+		var startedAt, endedAt sql.NullInt64
+		err := rows.Scan(&res.ID, &res.Name, &res.Email, &res.SessionsCount, &createdAt, &updatedAt, &startedAt, &endedAt)
+		if err != nil {
+			return out, err
+		}
+
+		res.FirstSeen, res.LastSeen = createdAt.Time, updatedAt.Time
+		if startedAt.Valid {
+			if t := fromUnixMillis(startedAt.Int64); res.FirstSeen.IsZero() || t.Before(res.FirstSeen) {
+				res.FirstSeen = t
+			}
+		}
+		if endedAt.Valid {
+			if t := fromUnixMillis(endedAt.Int64); t.After(res.LastSeen) {
+				res.LastSeen = t
+			}
+		}
+
+		out = append(out, res)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,120 @@
+package rrweb
+
+import (
+	"encoding/json"
+)
+
+// EventType defines rrweb event types
+type EventType int
+
+// rrweb event types (see rrweb/src/types.ts)
+const (
+	EventDomContentLoaded EventType = iota
+	EventLoad
+	EventFullSnapshot
+	EventIncrementalSnapshot
+	EventMeta
+	EventCustom
+	EventPlugin
+)
+
+// IncrementalSource defines the source of an incremental snapshot event
+type IncrementalSource int
+
+// rrweb incremental snapshot sources (see rrweb/src/types.ts)
+const (
+	SourceMutation IncrementalSource = iota
+	SourceMouseMove
+	SourceMouseInteraction
+	SourceScroll
+	SourceViewportResize
+	SourceInput
+)
+
+// NodeType defines rrweb-snapshot serialised node types
+type NodeType int
+
+// rrweb-snapshot node types (see rrweb-snapshot/src/types.ts)
+const (
+	NodeDocument NodeType = iota
+	NodeDocumentType
+	NodeElement
+	NodeText
+	NodeCDATA
+	NodeComment
+)
+
+type (
+	// Event is an rrweb event, keeping its data raw until it is required
+	Event struct {
+		Type      EventType       `json:"type"`
+		Data      json.RawMessage `json:"data"`
+		Timestamp int64           `json:"timestamp"`
+	}
+
+	// Node is an rrweb-snapshot serialised DOM node
+	Node struct {
+		Type        NodeType `json:"type"`
+		TagName     string   `json:"tagName"`
+		TextContent string   `json:"textContent"`
+		IsStyle     bool     `json:"isStyle"`
+		ChildNodes  []Node   `json:"childNodes"`
+	}
+
+	// MetaData is the data of an EventMeta event, sent on every page load
+	MetaData struct {
+		Href   string `json:"href"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	}
+
+	// CustomData is the data of an EventCustom event
+	CustomData struct {
+		Tag     string          `json:"tag"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	// PluginData is the data of an EventPlugin event, such as the ones from rrweb/console
+	PluginData struct {
+		Plugin  string `json:"plugin"`
+		Payload struct {
+			Level string `json:"level"`
+		} `json:"payload"`
+	}
+
+	// FullSnapshotData is the data of an EventFullSnapshot event
+	FullSnapshotData struct {
+		Node Node `json:"node"`
+	}
+
+	// IncrementalData is the data of an EventIncrementalSnapshot event. Only the fields
+	// required by Jornada are mapped and they depend on Source.
+	IncrementalData struct {
+		Source IncrementalSource `json:"source"`
+
+		// SourceMutation
+		Texts []struct {
+			Value string `json:"value"`
+		} `json:"texts"`
+		Adds []struct {
+			Node Node `json:"node"`
+		} `json:"adds"`
+
+		// SourceInput
+		Text string `json:"text"`
+	}
+)
+
+// Parse parses raw rrweb events
+func Parse(msgs ...[]byte) ([]Event, error) {
+	events := make([]Event, 0, len(msgs))
+	for _, msg := range msgs {
+		var event Event
+		if err := json.Unmarshal(msg, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,64 @@
+package rrweb
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// consolePlugin is the plugin name used by rrweb console recorder
+const consolePlugin = "rrweb/console@1"
+
+// Metrics summarises a batch of events
+type Metrics struct {
+	EventsCount    uint64
+	PagesCount     uint64
+	HasError       bool
+	FirstTimestamp int64
+	LastTimestamp  int64
+	LastURL        string
+}
+
+// Summarise computes metrics for a batch of events. Pages are counted through meta events (sent on
+// every page load) and errors are detected through console errors (rrweb/console plugin) or custom
+// events tagged as `error`. Events which data can't be decoded are only counted, so they don't
+// prevent the others from being summarised.
+func Summarise(events ...Event) Metrics {
+	m := Metrics{EventsCount: uint64(len(events))}
+
+	for _, event := range events {
+		if event.Timestamp > 0 && (m.FirstTimestamp == 0 || event.Timestamp < m.FirstTimestamp) {
+			m.FirstTimestamp = event.Timestamp
+		}
+		if event.Timestamp > m.LastTimestamp {
+			m.LastTimestamp = event.Timestamp
+		}
+
+		switch event.Type {
+		case EventMeta:
+			var data MetaData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				continue
+			}
+			m.PagesCount++
+			m.LastURL = data.Href
+		case EventCustom:
+			var data CustomData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				continue
+			}
+			if strings.EqualFold(data.Tag, "error") {
+				m.HasError = true
+			}
+		case EventPlugin:
+			var data PluginData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				continue
+			}
+			if data.Plugin == consolePlugin && data.Payload.Level == "error" {
+				m.HasError = true
+			}
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,56 @@
+package rrweb_test
+
+import (
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarise(t *testing.T) {
+	events, err := rrweb.Parse(
+		[]byte(`{"type":4,"data":{"href":"http://localhost/","width":800,"height":600},"timestamp":1000}`),
+		[]byte(`{"type":2,"data":{"node":{"type":0,"childNodes":[]}},"timestamp":1001}`),
+		[]byte(`{"type":6,"data":{"plugin":"rrweb/console@1","payload":{"level":"log","payload":["ok"]}},"timestamp":2000}`),
+		[]byte(`{"type":4,"data":{"href":"http://localhost/checkout","width":800,"height":600},"timestamp":3000}`),
+		[]byte(`{"type":3,"data":{"source":1,"positions":[]},"timestamp":4500}`),
+	)
+	require.NoError(t, err)
+
+	m := rrweb.Summarise(events...)
+	require.Equal(t, rrweb.Metrics{
+		EventsCount:    5,
+		PagesCount:     2,
+		FirstTimestamp: 1000,
+		LastTimestamp:  4500,
+		LastURL:        "http://localhost/checkout",
+	}, m)
+
+	events, err = rrweb.Parse(
+		[]byte(`{"type":6,"data":{"plugin":"rrweb/console@1","payload":{"level":"error","payload":["oops"]}},"timestamp":1}`),
+	)
+	require.NoError(t, err)
+	m = rrweb.Summarise(events...)
+	require.True(t, m.HasError)
+
+	events, err = rrweb.Parse([]byte(`{"type":5,"data":{"tag":"error","payload":{}},"timestamp":1}`))
+	require.NoError(t, err)
+	m = rrweb.Summarise(events...)
+	require.True(t, m.HasError)
+
+	// events which can't be decoded don't prevent the others from being summarised
+	events, err = rrweb.Parse(
+		[]byte(`{"type":6,"data":{"plugin":"rrweb/console@1","payload":"oops"},"timestamp":1}`),
+		[]byte(`{"type":4,"data":{"href":"http://localhost/"},"timestamp":2}`),
+		[]byte(`{"type":5,"data":{"tag":"error","payload":{}},"timestamp":3}`),
+	)
+	require.NoError(t, err)
+	require.Equal(t, rrweb.Metrics{
+		EventsCount:    3,
+		PagesCount:     1,
+		HasError:       true,
+		FirstTimestamp: 1,
+		LastTimestamp:  3,
+		LastURL:        "http://localhost/",
+	}, rrweb.Summarise(events...))
+}
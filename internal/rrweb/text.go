@@ -0,0 +1,75 @@
+package rrweb
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// skippedTags are elements which text content is not visible to users
+var skippedTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+}
+
+// Texts extracts the text seen or typed by users: text nodes from full snapshots, text mutations,
+// added text nodes and input values. Texts are trimmed and de-duplicated, keeping their order.
+func Texts(events ...Event) ([]string, error) {
+	seen := map[string]bool{}
+	out := []string{}
+	add := func(text string) {
+		text = strings.TrimSpace(text)
+		if text == "" || seen[text] {
+			return
+		}
+		seen[text] = true
+		out = append(out, text)
+	}
+
+	for _, event := range events {
+		switch event.Type {
+		case EventFullSnapshot:
+			var data FullSnapshotData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				return nil, err
+			}
+			nodeTexts(data.Node, add)
+		case EventIncrementalSnapshot:
+			var data IncrementalData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				return nil, err
+			}
+
+			switch data.Source {
+			case SourceMutation:
+				for _, text := range data.Texts {
+					add(text.Value)
+				}
+				for _, added := range data.Adds {
+					nodeTexts(added.Node, add)
+				}
+			case SourceInput:
+				add(data.Text)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func nodeTexts(node Node, add func(string)) {
+	switch node.Type {
+	case NodeText:
+		if !node.IsStyle {
+			add(node.TextContent)
+		}
+	case NodeElement:
+		if skippedTags[strings.ToLower(node.TagName)] {
+			return
+		}
+	}
+
+	for _, child := range node.ChildNodes {
+		nodeTexts(child, add)
+	}
+}
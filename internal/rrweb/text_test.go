@@ -0,0 +1,33 @@
+package rrweb_test
+
+import (
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTexts(t *testing.T) {
+	events, err := rrweb.Parse(
+		[]byte(`{"type":4,"data":{"href":"http://localhost/checkout","width":800,"height":600},"timestamp":1}`),
+		[]byte(`{"type":2,"data":{"node":{"type":0,"childNodes":[
+			{"type":2,"tagName":"head","childNodes":[
+				{"type":2,"tagName":"style","childNodes":[{"type":3,"textContent":"body { color: red }","isStyle":true}]},
+				{"type":2,"tagName":"script","childNodes":[{"type":3,"textContent":"console.log('hidden')"}]}
+			]},
+			{"type":2,"tagName":"body","childNodes":[
+				{"type":3,"textContent":"  Checkout  "},
+				{"type":2,"tagName":"p","childNodes":[{"type":3,"textContent":"Checkout"}]},
+				{"type":3,"textContent":"\n"}
+			]}
+		]}},"timestamp":2}`),
+		[]byte(`{"type":3,"data":{"source":0,"texts":[{"id":10,"value":"Checkout failed"}],"adds":[{"parentId":1,"node":{"type":3,"textContent":"Try again"}}],"removes":[],"attributes":[]},"timestamp":3}`),
+		[]byte(`{"type":3,"data":{"source":1,"positions":[{"x":1,"y":2,"id":3,"timeOffset":0}]},"timestamp":4}`),
+		[]byte(`{"type":3,"data":{"source":5,"id":12,"text":"john@example.com","isChecked":false},"timestamp":5}`),
+	)
+	require.NoError(t, err)
+
+	texts, err := rrweb.Texts(events...)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Checkout", "Checkout failed", "Try again", "john@example.com"}, texts)
+}
@@ -0,0 +1,39 @@
+package rrweb
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// Visit is a page visited during a session, taken from meta events
+type Visit struct {
+	URL       string
+	Path      string
+	Timestamp int64
+}
+
+// Visits extracts visited pages from meta events, which rrweb sends on every page load
+func Visits(events ...Event) ([]Visit, error) {
+	out := []Visit{}
+	for _, event := range events {
+		if event.Type != EventMeta {
+			continue
+		}
+
+		var data MetaData
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			return nil, err
+		}
+		if data.Href == "" {
+			continue
+		}
+
+		visit := Visit{URL: data.Href, Timestamp: event.Timestamp}
+		if u, err := url.Parse(data.Href); err == nil {
+			visit.Path = u.Path
+		}
+		out = append(out, visit)
+	}
+
+	return out, nil
+}
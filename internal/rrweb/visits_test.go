@@ -0,0 +1,24 @@
+package rrweb_test
+
+import (
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVisits(t *testing.T) {
+	events, err := rrweb.Parse(
+		[]byte(`{"type":4,"data":{"href":"http://localhost/products/1?ref=home","width":800,"height":600},"timestamp":1000}`),
+		[]byte(`{"type":2,"data":{"node":{"type":0,"childNodes":[]}},"timestamp":1001}`),
+		[]byte(`{"type":4,"data":{"href":"http://localhost/checkout","width":800,"height":600},"timestamp":3000}`),
+	)
+	require.NoError(t, err)
+
+	visits, err := rrweb.Visits(events...)
+	require.NoError(t, err)
+	require.Equal(t, []rrweb.Visit{
+		{URL: "http://localhost/products/1?ref=home", Path: "/products/1", Timestamp: 1000},
+		{URL: "http://localhost/checkout", Path: "/checkout", Timestamp: 3000},
+	}, visits)
+}
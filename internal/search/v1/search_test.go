@@ -16,27 +16,27 @@ func TestTransform(t *testing.T) {
 	}{
 		{
 			in:     "meta.foo = 'bar' AND meta.x = 'y'",
-			out:    "(meta.key = ? AND meta.value = ?) AND (meta.key = ? AND meta.value = ?)",
-			params: []interface{}{"foo", "bar", "x", "y"},
+			out:    "CAST(json_extract(s.meta, '$.foo') AS TEXT) = ? AND CAST(json_extract(s.meta, '$.x') AS TEXT) = ?",
+			params: []interface{}{"bar", "y"},
 		},
 		{
-			in:  "meta.foo = ';;bar' AND meta.x = 'y'",
-			err: true,
+			in:     "meta.foo = ';;bar' AND meta.x = 'y'",
+			out:    "CAST(json_extract(s.meta, '$.foo') AS TEXT) = ? AND CAST(json_extract(s.meta, '$.x') AS TEXT) = ?",
+			params: []interface{}{";;bar", "y"},
 		},
 		{
-			in:     "(meta.foo = 'bar' AND meta.x = 'y') OR value = '1'",
-			out:    "((meta.key = ? AND meta.value = ?) AND (meta.key = ? AND meta.value = ?)) OR value = ?",
-			params: []interface{}{"foo", "bar", "x", "y", "1"},
+			in:     "(meta.foo = 'bar' AND meta.x = 'y') OR device = '1'",
+			out:    "(CAST(json_extract(s.meta, '$.foo') AS TEXT) = ? AND CAST(json_extract(s.meta, '$.x') AS TEXT) = ?) OR s.device = ?",
+			params: []interface{}{"bar", "y", "1"},
 		},
 		{
-			in:     "(meta.foo = 10 AND meta.x = 'y') OR value = '1'",
-			out:    "((meta.key = ? AND meta.value = ?) AND (meta.key = ? AND meta.value = ?)) OR value = ?",
-			params: []interface{}{"foo", "10", "x", "y", "1"},
+			in:     "(meta.foo = 10 AND meta.x = \"y\") OR device = '1'",
+			out:    "(CASE WHEN json_type(s.meta, '$.foo') IN ('integer', 'real') THEN json_extract(s.meta, '$.foo') END = ? AND CAST(json_extract(s.meta, '$.x') AS TEXT) = ?) OR s.device = ?",
+			params: []interface{}{int64(10), "y", "1"},
 		},
 		{
-			in:     "meta.test = 'x' -- comment",
-			out:    "(meta.key = ? AND meta.value = ?)",
-			params: []interface{}{"test", "x"},
+			in:  "meta.test = 'x' -- comment",
+			err: true,
 		},
 	}
 
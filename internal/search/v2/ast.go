@@ -0,0 +1,207 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Node defines an AST node from a parsed query
+type Node interface {
+	// Position returns the column (1-based) where the node starts in the query
+	Position() int
+	String() string
+}
+
+// LogicalOp defines how two expressions are combined
+type LogicalOp string
+
+// Available logical operators
+const (
+	And LogicalOp = "AND"
+	Or  LogicalOp = "OR"
+)
+
+// Operator defines a comparison operator
+type Operator string
+
+// Available comparison operators
+const (
+	OpEq   Operator = "="
+	OpNeq  Operator = "!="
+	OpGt   Operator = ">"
+	OpGte  Operator = ">="
+	OpLt   Operator = "<"
+	OpLte  Operator = "<="
+	OpLike Operator = "~"
+
+	// OpContains matches values containing a text, which is compiled into LIKE '%text%'
+	OpContains Operator = "CONTAINS"
+)
+
+// SQL returns the SQL operator
+func (o Operator) SQL() string {
+	switch o {
+	case OpLike, OpContains:
+		return "LIKE"
+	case OpNeq:
+		return "<>"
+	}
+	return string(o)
+}
+
+// LiteralKind defines the kind of a literal value
+type LiteralKind int
+
+// Available literal kinds
+const (
+	LiteralString LiteralKind = iota
+	LiteralNumber
+	LiteralBool
+	LiteralTime
+)
+
+type (
+	// BinaryExpr combines two expressions with AND/OR
+	BinaryExpr struct {
+		Op    LogicalOp
+		Left  Node
+		Right Node
+	}
+
+	// NotExpr negates an expression
+	NotExpr struct {
+		Expr Node
+		Pos  int
+	}
+
+	// Comparison compares a field against a value, such as `os.name = 'Mac'`
+	Comparison struct {
+		Field Ident
+		Op    Operator
+		Value Literal
+	}
+
+	// In checks if a field is one of the values, such as `browser.name IN ('Chrome', 'Firefox')`
+	In struct {
+		Field  Ident
+		Values []Literal
+	}
+
+	// IsNull checks if a field is (or is not) set, such as `meta.foo IS NULL` or `EXISTS meta.foo`
+	IsNull struct {
+		Field Ident
+		Not   bool
+	}
+
+	// Match is a full-text match against a field, such as `text:"checkout failed"`
+	Match struct {
+		Field Ident
+		Value Literal
+	}
+
+	// Visit matches sessions which visited all steps in order, such as `visited '/cart' then '/checkout'`
+	Visit struct {
+		Steps []Literal
+		Pos   int
+	}
+
+	// Ident is a field reference, such as `browser.name` or `meta.foo`
+	Ident struct {
+		Name string
+		Pos  int
+	}
+
+	// Literal is a value used in comparisons. Relative times (LiteralTime) are kept unresolved,
+	// such as `now-2h` or `today`.
+	Literal struct {
+		Kind  LiteralKind
+		Value string
+		Pos   int
+	}
+)
+
+// Position returns where the node starts
+func (n BinaryExpr) Position() int { return n.Left.Position() }
+
+// Position returns where the node starts
+func (n NotExpr) Position() int { return n.Pos }
+
+// Position returns where the node starts
+func (n Comparison) Position() int { return n.Field.Pos }
+
+// Position returns where the node starts
+func (n In) Position() int { return n.Field.Pos }
+
+// Position returns where the node starts
+func (n IsNull) Position() int { return n.Field.Pos }
+
+// Position returns where the node starts
+func (n Match) Position() int { return n.Field.Pos }
+
+// Position returns where the node starts
+func (n Visit) Position() int { return n.Pos }
+
+// Position returns where the node starts
+func (n Ident) Position() int { return n.Pos }
+
+// Position returns where the node starts
+func (n Literal) Position() int { return n.Pos }
+
+func (n BinaryExpr) String() string {
+	return "(" + n.Left.String() + " " + string(n.Op) + " " + n.Right.String() + ")"
+}
+
+func (n NotExpr) String() string {
+	return "NOT " + n.Expr.String()
+}
+
+func (n Comparison) String() string {
+	return n.Field.String() + " " + string(n.Op) + " " + n.Value.String()
+}
+
+func (n In) String() string {
+	values := make([]string, 0, len(n.Values))
+	for _, value := range n.Values {
+		values = append(values, value.String())
+	}
+	return n.Field.String() + " IN (" + strings.Join(values, ", ") + ")"
+}
+
+func (n IsNull) String() string {
+	if n.Not {
+		return n.Field.String() + " IS NOT NULL"
+	}
+	return n.Field.String() + " IS NULL"
+}
+
+func (n Match) String() string {
+	return n.Field.String() + ":" + n.Value.String()
+}
+
+func (n Visit) String() string {
+	steps := make([]string, 0, len(n.Steps))
+	for _, step := range n.Steps {
+		steps = append(steps, step.String())
+	}
+	return "VISITED " + strings.Join(steps, " THEN ")
+}
+
+func (n Ident) String() string {
+	return n.Name
+}
+
+func (n Literal) String() string {
+	if n.Kind != LiteralString {
+		return n.Value
+	}
+	return "'" + strings.ReplaceAll(n.Value, "'", "''") + "'"
+}
+
+// Number returns the literal as a float64, if it is a number
+func (n Literal) Number() (float64, bool) {
+	if n.Kind != LiteralNumber {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(n.Value, 64)
+	return f, err == nil
+}
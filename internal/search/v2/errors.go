@@ -0,0 +1,17 @@
+package search
+
+import "fmt"
+
+// Error describes why a query could not be processed, pointing at the column (1-based) where it failed
+type Error struct {
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid query at column %d: %s", e.Column, e.Message)
+}
+
+func errorf(column int, format string, args ...interface{}) error {
+	return &Error{Column: column, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,228 @@
+package search
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+const (
+	// metaPrefix identifies fields which are looked up inside the session meta JSON
+	metaPrefix = "meta."
+
+	// textField is the field used for full-text searches over texts seen or typed in a session
+	textField = "text"
+
+	// urlField is the field used to search over pages visited in a session
+	urlField = "url"
+)
+
+var metaKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// fieldType defines the type of a field, which is used to validate and convert compared values
+type fieldType int
+
+const (
+	typeText fieldType = iota
+	typeNumber
+	typeBool
+	typeTime
+	typeMillis // times stored as unix milliseconds, such as rrweb timestamps
+	typeJSON   // meta values, whose type depends on the stored JSON value (see typed)
+)
+
+type field struct {
+	column string
+	typ    fieldType
+}
+
+// fields maps the fields available in queries to their columns on repo.SessionSQL queries
+var fields = map[string]field{
+	"id":              {"s.id", typeText},
+	"client_id":       {"s.client_id", typeText},
+	"device":          {"s.device", typeText},
+	"user_agent":      {"s.user_agent", typeText},
+	"created_at":      {"s.created_at", typeTime},
+	"updated_at":      {"s.updated_at", typeTime},
+	"started_at":      {"s.first_event_ts", typeMillis},
+	"ended_at":        {"s.last_event_ts", typeMillis},
+	"os.name":         {"os.name", typeText},
+	"os.version":      {"os.version", typeText},
+	"browser.name":    {"browser.name", typeText},
+	"browser.version": {"browser.version", typeText},
+	"user.id":         {"u.id", typeText},
+	"user.name":       {"u.name", typeText},
+	"user.email":      {"u.email", typeText},
+	"duration":        {"s.duration", typeNumber},
+	"events.count":    {"s.events_count", typeNumber},
+	"pages.count":     {"s.pages_count", typeNumber},
+	"has_error":       {"s.has_error", typeBool},
+	"last_url":        {"s.last_url", typeText},
+}
+
+// lookup resolves a field into its SQL expression and type
+func (c *compiler) lookup(ident Ident) (field, error) {
+	if f, ok := fields[ident.Name]; ok {
+		return f, nil
+	}
+
+	if strings.HasPrefix(ident.Name, metaPrefix) {
+		key := strings.TrimPrefix(ident.Name, metaPrefix)
+		if !metaKeyRegex.MatchString(key) {
+			return field{}, errorf(ident.Pos, "invalid meta key %q", key)
+		}
+		if col, ok := c.promoted(key, typeText); ok {
+			return field{col, typeJSON}, nil
+		}
+		col, err := c.jsonText("s.meta", key)
+		return field{col, typeJSON}, err
+	}
+
+	return field{}, errorf(ident.Pos, "unknown field %q", ident.Name)
+}
+
+// typed resolves the type of meta fields from the literal they are compared to: numbers and booleans
+// only match values stored with the same JSON type, while strings are compared to the value as text
+func (c *compiler) typed(f field, ident Ident, lit Literal) (field, error) {
+	if f.typ != typeJSON {
+		return f, nil
+	}
+
+	key := strings.TrimPrefix(ident.Name, metaPrefix)
+	switch lit.Kind {
+	case LiteralNumber:
+		return c.jsonField(key, typeNumber)
+	case LiteralBool:
+		return c.jsonField(key, typeBool)
+	case LiteralTime:
+		return f, errorf(lit.Pos, "field %q does not support relative times", ident.Name)
+	}
+
+	return field{f.column, typeText}, nil
+}
+
+// jsonField resolves a meta key holding numbers or booleans, using its generated column if it was promoted
+func (c *compiler) jsonField(key string, typ fieldType) (field, error) {
+	if col, ok := c.promoted(key, typ); ok {
+		return field{col, typ}, nil
+	}
+	col, err := c.jsonTyped("s.meta", key, typ)
+	return field{col, typ}, err
+}
+
+// value converts a literal into a SQL parameter, according to the field type
+func (c *compiler) value(f field, ident Ident, lit Literal) (interface{}, error) {
+	switch f.typ {
+	case typeTime:
+		return c.timeValue(ident, lit)
+	case typeMillis:
+		t, err := c.timeValue(ident, lit)
+		if err != nil {
+			return nil, err
+		}
+		return t.(time.Time).UnixNano() / int64(time.Millisecond), nil
+	case typeNumber:
+		if lit.Kind == LiteralString {
+			return nil, errorf(lit.Pos, "field %q expects a number", ident.Name)
+		}
+		if i, err := strconv.ParseInt(lit.Value, 10, 64); err == nil {
+			return i, nil
+		}
+		n, ok := lit.Number()
+		if !ok {
+			return nil, errorf(lit.Pos, "invalid number %s", lit.Value)
+		}
+		return n, nil
+	case typeBool:
+		if lit.Kind != LiteralBool {
+			return nil, errorf(lit.Pos, "field %q expects true or false", ident.Name)
+		}
+		return lit.Value == "true", nil
+	}
+
+	if lit.Kind == LiteralBool || lit.Kind == LiteralTime {
+		return nil, errorf(lit.Pos, "field %q expects a string", ident.Name)
+	}
+	return lit.Value, nil
+}
+
+// timeValue converts time strings and relative times into time.Time, using the same location as the
+// compiler clock. This matches how repo.SessionSQL saves them, as SQLite stores times as strings.
+func (c *compiler) timeValue(ident Ident, lit Literal) (interface{}, error) {
+	now := c.now()
+
+	switch lit.Kind {
+	case LiteralTime:
+		if t, ok := resolveTime(lit.Value, now); ok {
+			return t, nil
+		}
+	case LiteralString:
+		if t, ok := parseTime(lit.Value, now.Location()); ok {
+			return t.In(now.Location()), nil
+		}
+	}
+
+	return nil, errorf(lit.Pos, "field %q expects a time, such as '2021-03-01', '2021-03-01 10:00:00' or now-2h", ident.Name)
+}
+
+// jsonText returns the SQL expression which extracts a key from a JSON column as text. SQLite
+// json_extract returns native values, so they are cast (a stored 10 matches '10', as in the other dialects).
+// The key must be validated beforehand, as it is inlined in the expression.
+func (c *compiler) jsonText(col, key string) (string, error) {
+	switch c.dialect {
+	case sqldb.SQLite:
+		return "CAST(json_extract(" + col + ", '$." + key + "') AS TEXT)", nil
+	case sqldb.Postgres:
+		return "(" + col + " #>> '{" + strings.ReplaceAll(key, ".", ",") + "}')", nil
+	case sqldb.MySQL:
+		return "JSON_UNQUOTE(JSON_EXTRACT(" + col + ", '$." + key + "'))", nil
+	}
+
+	return "", errUnsupportedDialect(c.dialect)
+}
+
+// jsonTyped returns the SQL expression which extracts a number or boolean from a JSON column, being
+// NULL if the value has another JSON type. CASE is used, as the dialects don't guarantee the order in
+// which conditions are evaluated (and casts would fail for values of other types).
+func (c *compiler) jsonTyped(col, key string, typ fieldType) (string, error) {
+	switch c.dialect {
+	case sqldb.SQLite:
+		path := "'$." + key + "'"
+		types := "'integer', 'real'"
+		if typ == typeBool {
+			types = "'true', 'false'"
+		}
+		return "CASE WHEN json_type(" + col + ", " + path + ") IN (" + types + ") THEN json_extract(" + col + ", " + path + ") END", nil
+	case sqldb.Postgres:
+		path := "'{" + strings.ReplaceAll(key, ".", ",") + "}'"
+		if typ == typeBool {
+			return "CASE WHEN jsonb_typeof(" + col + " #> " + path + ") = 'boolean' THEN (" + col + " #>> " + path + ")::boolean END", nil
+		}
+		return "CASE WHEN jsonb_typeof(" + col + " #> " + path + ") = 'number' THEN (" + col + " #>> " + path + ")::numeric END", nil
+	case sqldb.MySQL:
+		value := "JSON_EXTRACT(" + col + ", '$." + key + "')"
+		if typ == typeBool {
+			return "CASE WHEN JSON_TYPE(" + value + ") = 'BOOLEAN' THEN JSON_UNQUOTE(" + value + ") = 'true' END", nil
+		}
+		return "CASE WHEN JSON_TYPE(" + value + ") IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') THEN CAST(JSON_UNQUOTE(" + value + ") AS DECIMAL(65, 30)) END", nil
+	}
+
+	return "", errUnsupportedDialect(c.dialect)
+}
+
+// Fields returns the names of all fields available in queries, sorted. Meta fields are not
+// included, as their keys are defined by each client.
+func Fields() []string {
+	out := make([]string, 0, len(fields)+2)
+	for name := range fields {
+		out = append(out, name)
+	}
+	out = append(out, textField, urlField)
+	sort.Strings(out)
+
+	return out
+}
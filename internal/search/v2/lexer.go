@@ -0,0 +1,274 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind defines the kind of a lexical token
+type TokenKind int
+
+// Available token kinds
+const (
+	TokenEOF TokenKind = iota
+	TokenIdent
+	TokenString
+	TokenNumber
+	TokenBool
+	TokenDuration
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenComma
+	TokenColon
+	TokenAnd
+	TokenOr
+	TokenNot
+	TokenIn
+	TokenVisited
+	TokenThen
+	TokenLike
+	TokenContains
+	TokenIs
+	TokenNull
+	TokenExists
+)
+
+var tokenNames = map[TokenKind]string{
+	TokenEOF:      "end of query",
+	TokenIdent:    "identifier",
+	TokenString:   "string",
+	TokenNumber:   "number",
+	TokenBool:     "boolean",
+	TokenDuration: "duration",
+	TokenOperator: "operator",
+	TokenLParen:   "'('",
+	TokenRParen:   "')'",
+	TokenComma:    "','",
+	TokenColon:    "':'",
+	TokenAnd:      "AND",
+	TokenOr:       "OR",
+	TokenNot:      "NOT",
+	TokenIn:       "IN",
+	TokenVisited:  "VISITED",
+	TokenThen:     "THEN",
+	TokenLike:     "LIKE",
+	TokenContains: "CONTAINS",
+	TokenIs:       "IS",
+	TokenNull:     "NULL",
+	TokenExists:   "EXISTS",
+}
+
+func (k TokenKind) String() string {
+	return tokenNames[k]
+}
+
+// keywords maps reserved words (case insensitive) to their token kinds
+var keywords = map[string]TokenKind{
+	"AND":      TokenAnd,
+	"OR":       TokenOr,
+	"NOT":      TokenNot,
+	"IN":       TokenIn,
+	"VISITED":  TokenVisited,
+	"THEN":     TokenThen,
+	"LIKE":     TokenLike,
+	"CONTAINS": TokenContains,
+	"IS":       TokenIs,
+	"NULL":     TokenNull,
+	"EXISTS":   TokenExists,
+	"TRUE":     TokenBool,
+	"FALSE":    TokenBool,
+}
+
+// Token defines a lexical token, with its position (1-based column) in the query
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Pos   int
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case TokenEOF:
+		return t.Kind.String()
+	case TokenString:
+		return "'" + t.Value + "'"
+	default:
+		return t.Value
+	}
+}
+
+// Lex splits a query into tokens. The last token is always TokenEOF.
+func Lex(in string) ([]Token, error) {
+	l := lexer{in: []rune(in)}
+	return l.run()
+}
+
+type lexer struct {
+	in  []rune
+	pos int
+}
+
+func (l *lexer) run() (tokens []Token, err error) {
+	for {
+		l.skipSpaces()
+		if l.pos >= len(l.in) {
+			return append(tokens, Token{Kind: TokenEOF, Pos: l.pos + 1}), nil
+		}
+
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+func (l *lexer) next() (Token, error) {
+	start := l.pos
+	r := l.in[l.pos]
+
+	switch {
+	case r == '(':
+		l.pos++
+		return Token{Kind: TokenLParen, Value: "(", Pos: start + 1}, nil
+	case r == ')':
+		l.pos++
+		return Token{Kind: TokenRParen, Value: ")", Pos: start + 1}, nil
+	case r == ',':
+		l.pos++
+		return Token{Kind: TokenComma, Value: ",", Pos: start + 1}, nil
+	case r == ':':
+		l.pos++
+		return Token{Kind: TokenColon, Value: ":", Pos: start + 1}, nil
+	case r == '\'' || r == '"':
+		return l.string(r)
+	case r == '=' || r == '<' || r == '>' || r == '!' || r == '~':
+		return l.operator()
+	case unicode.IsDigit(r) || ((r == '-' || r == '+') && unicode.IsDigit(l.peek(1))):
+		return l.number()
+	case r == '-' || r == '+':
+		l.pos++
+		return Token{Kind: TokenOperator, Value: string(r), Pos: start + 1}, nil
+	case isIdentStart(r):
+		return l.ident(), nil
+	}
+
+	return Token{}, errorf(start+1, "unexpected character %q", r)
+}
+
+func (l *lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.in) {
+		return 0
+	}
+	return l.in[l.pos+offset]
+}
+
+func (l *lexer) skipSpaces() {
+	for l.pos < len(l.in) && unicode.IsSpace(l.in[l.pos]) {
+		l.pos++
+	}
+}
+
+// string reads a quoted string. Quotes can be escaped by repeating them twice or with a backslash.
+func (l *lexer) string(quote rune) (Token, error) {
+	start := l.pos
+	l.pos++
+
+	var b strings.Builder
+	for l.pos < len(l.in) {
+		r := l.in[l.pos]
+		switch {
+		case r == '\\' && l.pos+1 < len(l.in):
+			b.WriteRune(l.in[l.pos+1])
+			l.pos += 2
+		case r == quote && l.peek(1) == quote:
+			b.WriteRune(quote)
+			l.pos += 2
+		case r == quote:
+			l.pos++
+			return Token{Kind: TokenString, Value: b.String(), Pos: start + 1}, nil
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+
+	return Token{}, errorf(start+1, "unterminated string")
+}
+
+func (l *lexer) operator() (Token, error) {
+	start := l.pos
+	r := l.in[l.pos]
+	l.pos++
+
+	op := string(r)
+	switch next := l.peek(0); {
+	case next == '=' && r != '=':
+		op += "="
+		l.pos++
+	case r == '<' && next == '>':
+		op += ">"
+		l.pos++
+	}
+
+	if op == "!" {
+		return Token{}, errorf(start+1, "unexpected character '!', did you mean '!='?")
+	}
+
+	return Token{Kind: TokenOperator, Value: op, Pos: start + 1}, nil
+}
+
+// number reads a number or a duration, which is a number followed by an unit (such as 2h or 7d)
+func (l *lexer) number() (Token, error) {
+	start := l.pos
+	if l.in[l.pos] == '-' || l.in[l.pos] == '+' {
+		l.pos++
+	}
+
+	dot := false
+	for l.pos < len(l.in) {
+		r := l.in[l.pos]
+		if r == '.' && !dot {
+			dot = true
+		} else if !unicode.IsDigit(r) {
+			break
+		}
+		l.pos++
+	}
+
+	if l.pos < len(l.in) && isIdentStart(l.in[l.pos]) {
+		if _, ok := durationUnits[l.in[l.pos]]; ok && !dot && !isIdentPart(l.peek(1)) {
+			l.pos++
+			return Token{Kind: TokenDuration, Value: string(l.in[start:l.pos]), Pos: start + 1}, nil
+		}
+		return Token{}, errorf(l.pos+1, "unexpected character %q after number", l.in[l.pos])
+	}
+
+	return Token{Kind: TokenNumber, Value: string(l.in[start:l.pos]), Pos: start + 1}, nil
+}
+
+func (l *lexer) ident() Token {
+	start := l.pos
+	for l.pos < len(l.in) && isIdentPart(l.in[l.pos]) {
+		l.pos++
+	}
+
+	value := string(l.in[start:l.pos])
+	if kind, ok := keywords[strings.ToUpper(value)]; ok {
+		if kind == TokenBool {
+			return Token{Kind: kind, Value: strings.ToLower(value), Pos: start + 1}
+		}
+		return Token{Kind: kind, Value: strings.ToUpper(value), Pos: start + 1}
+	}
+
+	return Token{Kind: TokenIdent, Value: value, Pos: start + 1}
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
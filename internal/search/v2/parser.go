@@ -0,0 +1,357 @@
+package search
+
+import "strings"
+
+// Grammar:
+//
+//   query      = or EOF
+//   or         = and { "OR" and }
+//   and        = unary { "AND" unary }
+//   unary      = "NOT" unary | primary
+//   primary    = "(" or ")" | match | visit | exists | comparison
+//   match      = ident ":" string
+//   visit      = "VISITED" string { "THEN" string }
+//   exists     = "EXISTS" ident
+//   comparison = ident operator literal
+//              | ident [ "NOT" ] "IN" "(" literal { "," literal } ")"
+//              | ident [ "NOT" ] "IN" "LAST" duration
+//              | ident [ "NOT" ] "LIKE" string
+//              | ident "CONTAINS" string
+//              | ident "IS" [ "NOT" ] "NULL"
+//   operator   = "=" | "!=" | "<>" | ">" | ">=" | "<" | "<=" | "~"
+//   literal    = string | number | "TRUE" | "FALSE" | time
+//   time       = ( "NOW" | "TODAY" | "YESTERDAY" ) [ [ "+" | "-" ] duration ]
+//   duration   = number ( "s" | "m" | "h" | "d" | "w" )
+
+var operators = map[string]Operator{
+	"=":  OpEq,
+	"!=": OpNeq,
+	"<>": OpNeq,
+	">":  OpGt,
+	">=": OpGte,
+	"<":  OpLt,
+	"<=": OpLte,
+	"~":  OpLike,
+}
+
+// Parse parses a query into an AST
+func Parse(in string) (Node, error) {
+	tokens, err := Lex(in)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, errorf(tok.Pos, "unexpected %s, expected AND, OR or end of query", tok)
+	}
+
+	return node, nil
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) or() (Node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().Kind == TokenOr {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{Op: Or, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) and() (Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().Kind == TokenAnd {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = BinaryExpr{Op: And, Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) unary() (Node, error) {
+	if tok := p.peek(); tok.Kind == TokenNot {
+		p.next()
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return NotExpr{Expr: expr, Pos: tok.Pos}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (Node, error) {
+	tok := p.peek()
+	switch tok.Kind {
+	case TokenLParen:
+		p.next()
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Kind != TokenRParen {
+			return nil, errorf(closing.Pos, "unexpected %s, expected ')' to close '(' at column %d", closing, tok.Pos)
+		}
+		return expr, nil
+	case TokenVisited:
+		return p.visit()
+	case TokenExists:
+		return p.exists()
+	case TokenIdent:
+		if p.tokens[p.pos+1].Kind == TokenColon {
+			return p.match()
+		}
+		return p.comparison()
+	}
+
+	return nil, errorf(tok.Pos, "unexpected %s, expected field, VISITED or '('", tok)
+}
+
+func (p *parser) visit() (Node, error) {
+	node := Visit{Pos: p.next().Pos}
+
+	for {
+		tok := p.next()
+		if tok.Kind != TokenString {
+			return nil, errorf(tok.Pos, "unexpected %s, expected an URL or path string", tok)
+		}
+		node.Steps = append(node.Steps, Literal{Kind: LiteralString, Value: tok.Value, Pos: tok.Pos})
+
+		if p.peek().Kind != TokenThen {
+			return node, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) comparison() (Node, error) {
+	tok := p.next()
+	field := Ident{Name: tok.Value, Pos: tok.Pos}
+
+	tok = p.next()
+	not := tok.Kind == TokenNot
+	if not {
+		if tok = p.next(); tok.Kind != TokenIn && tok.Kind != TokenLike {
+			return nil, errorf(tok.Pos, "unexpected %s, expected IN or LIKE after NOT", tok)
+		}
+	}
+
+	var node Node
+	var err error
+	switch tok.Kind {
+	case TokenIn:
+		node, err = p.in(field)
+	case TokenLike, TokenContains:
+		node, err = p.pattern(field, tok)
+	case TokenIs:
+		node, err = p.isNull(field)
+	case TokenOperator:
+		node, err = p.operator(field, tok)
+	default:
+		err = errorf(tok.Pos, "unexpected %s, expected operator after %s", tok, field)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if not {
+		return NotExpr{Expr: node, Pos: field.Pos}, nil
+	}
+	return node, nil
+}
+
+func (p *parser) operator(field Ident, tok Token) (Node, error) {
+	op, ok := operators[tok.Value]
+	if !ok {
+		return nil, errorf(tok.Pos, "operator %s is not supported", tok.Value)
+	}
+
+	value, err := p.literal()
+	if err != nil {
+		return nil, err
+	}
+
+	return Comparison{Field: field, Op: op, Value: value}, nil
+}
+
+// in parses `field IN (values...)` and `field IN LAST duration`, which is the same as `field >= now-duration`
+func (p *parser) in(field Ident) (Node, error) {
+	tok := p.next()
+	if tok.Kind == TokenLParen {
+		return p.list(field)
+	}
+	if tok.Kind != TokenIdent || !strings.EqualFold(tok.Value, "last") {
+		return nil, errorf(tok.Pos, "unexpected %s, expected '(' or LAST", tok)
+	}
+
+	tok = p.next()
+	if tok.Kind != TokenDuration || strings.IndexAny(tok.Value, "+-") == 0 {
+		return nil, errorf(tok.Pos, "unexpected %s, expected a duration such as 7d", tok)
+	}
+
+	return Comparison{
+		Field: field,
+		Op:    OpGte,
+		Value: Literal{Kind: LiteralTime, Value: "now-" + tok.Value, Pos: tok.Pos},
+	}, nil
+}
+
+// list parses the values of `field IN (values...)`, after the opening parenthesis
+func (p *parser) list(field Ident) (Node, error) {
+	node := In{Field: field}
+	for {
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		node.Values = append(node.Values, value)
+
+		switch tok := p.next(); tok.Kind {
+		case TokenRParen:
+			return node, nil
+		case TokenComma:
+		default:
+			return nil, errorf(tok.Pos, "unexpected %s, expected ',' or ')'", tok)
+		}
+	}
+}
+
+// pattern parses `field LIKE 'pattern'` and `field CONTAINS 'text'`
+func (p *parser) pattern(field Ident, tok Token) (Node, error) {
+	op := OpLike
+	if tok.Kind == TokenContains {
+		op = OpContains
+	}
+
+	value := p.next()
+	if value.Kind != TokenString {
+		return nil, errorf(value.Pos, "unexpected %s, expected a string after %s", value, tok.Value)
+	}
+
+	return Comparison{
+		Field: field,
+		Op:    op,
+		Value: Literal{Kind: LiteralString, Value: value.Value, Pos: value.Pos},
+	}, nil
+}
+
+// isNull parses `field IS [NOT] NULL`
+func (p *parser) isNull(field Ident) (Node, error) {
+	node := IsNull{Field: field}
+
+	tok := p.next()
+	if tok.Kind == TokenNot {
+		node.Not = true
+		tok = p.next()
+	}
+	if tok.Kind != TokenNull {
+		return nil, errorf(tok.Pos, "unexpected %s, expected NULL", tok)
+	}
+
+	return node, nil
+}
+
+// exists parses `EXISTS field`, which is the same as `field IS NOT NULL`
+func (p *parser) exists() (Node, error) {
+	p.next()
+
+	tok := p.next()
+	if tok.Kind != TokenIdent {
+		return nil, errorf(tok.Pos, "unexpected %s, expected field after EXISTS", tok)
+	}
+
+	return IsNull{Field: Ident{Name: tok.Value, Pos: tok.Pos}, Not: true}, nil
+}
+
+func (p *parser) match() (Node, error) {
+	field := p.next()
+	p.next()
+
+	tok := p.next()
+	if tok.Kind != TokenString {
+		return nil, errorf(tok.Pos, "unexpected %s, expected a string after %s:", tok, field)
+	}
+
+	return Match{
+		Field: Ident{Name: field.Value, Pos: field.Pos},
+		Value: Literal{Kind: LiteralString, Value: tok.Value, Pos: tok.Pos},
+	}, nil
+}
+
+func (p *parser) literal() (Literal, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case TokenString:
+		return Literal{Kind: LiteralString, Value: tok.Value, Pos: tok.Pos}, nil
+	case TokenNumber:
+		return Literal{Kind: LiteralNumber, Value: tok.Value, Pos: tok.Pos}, nil
+	case TokenBool:
+		return Literal{Kind: LiteralBool, Value: tok.Value, Pos: tok.Pos}, nil
+	case TokenIdent:
+		if anchor := strings.ToLower(tok.Value); timeAnchors[anchor] {
+			return p.relativeTime(anchor, tok.Pos)
+		}
+	}
+
+	return Literal{}, errorf(tok.Pos, "unexpected %s, expected a string, number, boolean or time", tok)
+}
+
+// relativeTime parses the optional offset after a time anchor, such as the `-2h` in `now-2h`
+func (p *parser) relativeTime(anchor string, pos int) (Literal, error) {
+	lit := Literal{Kind: LiteralTime, Value: anchor, Pos: pos}
+
+	switch tok := p.peek(); {
+	case tok.Kind == TokenDuration && strings.IndexAny(tok.Value, "+-") == 0:
+		p.next()
+		lit.Value += tok.Value
+	case tok.Kind == TokenOperator && (tok.Value == "+" || tok.Value == "-"):
+		p.next()
+		dur := p.next()
+		if dur.Kind != TokenDuration || strings.IndexAny(dur.Value, "+-") == 0 {
+			return lit, errorf(dur.Pos, "unexpected %s, expected a duration such as 2h", dur)
+		}
+		lit.Value += tok.Value + dur.Value
+	}
+
+	return lit, nil
+}
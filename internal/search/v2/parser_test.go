@@ -0,0 +1,141 @@
+package search_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in     string
+		out    string
+		column int
+	}{
+		{
+			in:  "os.name = 'Mac'",
+			out: "os.name = 'Mac'",
+		},
+		{
+			in:  "meta.foo = 'bar' AND meta.x = 10 OR browser.name = \"Firefox\"",
+			out: "((meta.foo = 'bar' AND meta.x = 10) OR browser.name = 'Firefox')",
+		},
+		{
+			in:  "meta.foo = 'bar' and (meta.x = 'y' or not device = 'iPhone')",
+			out: "(meta.foo = 'bar' AND (meta.x = 'y' OR NOT device = 'iPhone'))",
+		},
+		{
+			in:  "meta.name = 'O''Brien'",
+			out: "meta.name = 'O''Brien'",
+		},
+		{
+			in:  "os.version >= 10.5 AND os.version < -1",
+			out: "(os.version >= 10.5 AND os.version < -1)",
+		},
+		{
+			in:  "text:'checkout failed' OR NOT text : \"oops\"",
+			out: "(text:'checkout failed' OR NOT text:'oops')",
+		},
+		{
+			in:  "updated_at > now-2h AND updated_at < NOW - 30m OR updated_at in last 7d OR updated_at >= today",
+			out: "(((updated_at > now-2h AND updated_at < now-30m) OR updated_at >= now-7d) OR updated_at >= today)",
+		},
+		{
+			in:     "updated_at in last -7d",
+			column: 20,
+		},
+		{
+			in:     "updated_at > now - 2",
+			column: 20,
+		},
+		{
+			in:  "visited '/cart' THEN \"/checkout\" and url ~ '/products/%'",
+			out: "(VISITED '/cart' THEN '/checkout' AND url ~ '/products/%')",
+		},
+		{
+			in:  "browser.name != 'Chrome' and os.name <> 'Mac' and browser.name not in ('Firefox', \"Safari\")",
+			out: "((browser.name != 'Chrome' AND os.name != 'Mac') AND NOT browser.name IN ('Firefox', 'Safari'))",
+		},
+		{
+			in:  "meta.plan like 'pro%' or meta.plan NOT LIKE '%trial' or last_url contains '/cart'",
+			out: "((meta.plan ~ 'pro%' OR NOT meta.plan ~ '%trial') OR last_url CONTAINS '/cart')",
+		},
+		{
+			in:  "meta.foo is null and meta.bar IS NOT NULL and not exists meta.baz",
+			out: "((meta.foo IS NULL AND meta.bar IS NOT NULL) AND NOT meta.baz IS NOT NULL)",
+		},
+		{
+			in:     "browser.name in ('Firefox' 'Safari')",
+			column: 28,
+		},
+		{
+			in:     "browser.name in ()",
+			column: 18,
+		},
+		{
+			in:     "meta.foo is not 'a'",
+			column: 17,
+		},
+		{
+			in:     "meta.foo not = 'a'",
+			column: 14,
+		},
+		{
+			in:     "exists 'a'",
+			column: 8,
+		},
+		{
+			in:     "visited '/cart' then",
+			column: 21,
+		},
+		{
+			in:     "text: 10",
+			column: 7,
+		},
+		{
+			in:     "os.name = 'Mac' --",
+			column: 17,
+		},
+		{
+			in:     "os.name 'Mac'",
+			column: 9,
+		},
+		{
+			in:     "(os.name = 'Mac'",
+			column: 17,
+		},
+		{
+			in:     "os.name = 'Mac",
+			column: 11,
+		},
+		{
+			in:     "os.name = 'Mac' AND",
+			column: 20,
+		},
+		{
+			in:     "os.name = 'Mac' os.version = '1'",
+			column: 17,
+		},
+		{
+			in:     "os.name ! 'Mac'",
+			column: 9,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			node, err := search.Parse(test.in)
+			if test.column != 0 {
+				var serr *search.Error
+				require.True(t, errors.As(err, &serr), "expected *search.Error, got %v", err)
+				require.Equal(t, test.column, serr.Column)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.out, node.String())
+		})
+	}
+}
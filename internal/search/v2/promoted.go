@@ -0,0 +1,101 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// PromotedKey is a meta key which is indexed, so searches over it don't need to scan all sessions.
+// Keys are indexed by the type of value they are compared to (see typed): text by default, number or
+// bool if the key is suffixed with `:number` or `:bool`, such as `cart.total:number`.
+type PromotedKey struct {
+	Key  string
+	Type string
+	typ  fieldType
+}
+
+var promotedTypes = map[string]fieldType{
+	"text":   typeText,
+	"number": typeNumber,
+	"bool":   typeBool,
+}
+
+// ParsePromotedKeys parses promoted keys, such as `plan` or `cart.total:number`. Values may hold
+// multiple comma-separated keys.
+func ParsePromotedKeys(in []string) ([]PromotedKey, error) {
+	out := make([]PromotedKey, 0, len(in))
+	columns := map[string]string{}
+	for _, s := range strings.Split(strings.Join(in, ","), ",") {
+		s = strings.TrimPrefix(strings.TrimSpace(s), metaPrefix)
+		if s == "" {
+			continue
+		}
+
+		key, typ := s, "text"
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			key, typ = s[:i], s[i+1:]
+		}
+		if !metaKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid promoted meta key %q", key)
+		}
+		ft, ok := promotedTypes[typ]
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q for promoted meta key %q, expected text, number or bool", typ, key)
+		}
+
+		k := PromotedKey{Key: key, Type: typ, typ: ft}
+		if prev, ok := columns[k.Column()]; ok {
+			return nil, fmt.Errorf("promoted meta keys %q and %q conflict", prev, k)
+		}
+		columns[k.Column()] = k.String()
+		out = append(out, k)
+	}
+
+	return out, nil
+}
+
+// String returns the key as it is configured
+func (k PromotedKey) String() string {
+	if k.Type == "text" {
+		return k.Key
+	}
+	return k.Key + ":" + k.Type
+}
+
+// Column returns the name of the generated column which holds the key values (SQLite), which is also
+// used to name its index
+func (k PromotedKey) Column() string {
+	prefix := "meta"
+	if k.Type != "text" {
+		prefix += "_" + k.Type
+	}
+	return prefix + "__" + strings.ReplaceAll(k.Key, ".", "__")
+}
+
+// Expr returns the SQL expression which extracts the key values from a JSON column. It is the same
+// expression used by the compiler, so PostgreSQL uses expression indexes built over it.
+func (k PromotedKey) Expr(dialect sqldb.Dialect, col string) (string, error) {
+	c := compiler{dialect: dialect}
+	if k.typ == typeText {
+		return c.jsonText(col, k.Key)
+	}
+	return c.jsonTyped(col, k.Key, k.typ)
+}
+
+// promoted returns the generated column which holds the values of a meta key, if it was promoted with
+// the given type. Only SQLite uses generated columns: PostgreSQL matches expression indexes against the
+// compiled expressions, so they don't need to be routed.
+func (c *compiler) promoted(key string, typ fieldType) (string, bool) {
+	if c.dialect != sqldb.SQLite {
+		return "", false
+	}
+
+	for _, k := range c.promotedKeys {
+		if k.Key == key && k.typ == typ {
+			return "s." + k.Column(), true
+		}
+	}
+	return "", false
+}
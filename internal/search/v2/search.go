@@ -0,0 +1,235 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+)
+
+// Compiler transforms queries into SQL conditions for a certain SQL dialect
+type Compiler struct {
+	Dialect sqldb.Dialect
+
+	// Now is the clock used to resolve relative times (time.Now by default)
+	Now func() time.Time
+
+	// Promoted are the indexed meta keys, which are looked up through their indexes
+	Promoted []PromotedKey
+}
+
+// NewCompiler returns a compiler for the given dialect
+func NewCompiler(dialect sqldb.Dialect) *Compiler {
+	return &Compiler{Dialect: dialect, Now: time.Now}
+}
+
+// ToSQL parses an input query and compiles it into a SQL condition. Values are always passed as `?`
+// placeholders, as the output is meant to be used as a squirrel condition: the query builder will
+// replace them with the dialect placeholders (see sqldb.Dialect.Placeholder).
+func (c *Compiler) ToSQL(in string) (out string, params []interface{}, err error) {
+	node, err := Parse(in)
+	if err != nil {
+		return out, params, err
+	}
+
+	return c.Compile(node)
+}
+
+// Compile compiles an AST into a SQL condition, where all values are passed as `?` placeholders
+func (c *Compiler) Compile(node Node) (out string, params []interface{}, err error) {
+	cc := compiler{dialect: c.Dialect, now: c.Now, promotedKeys: c.Promoted}
+	if cc.now == nil {
+		cc.now = time.Now
+	}
+	if err := cc.compile(node, ""); err != nil {
+		return out, params, err
+	}
+
+	return cc.sql.String(), cc.params, nil
+}
+
+// ToSQL parses an input query and compiles it into a SQLite condition
+func ToSQL(in string) (out string, params []interface{}, err error) {
+	return NewCompiler(sqldb.SQLite).ToSQL(in)
+}
+
+// Compile compiles an AST into a SQLite condition
+func Compile(node Node) (out string, params []interface{}, err error) {
+	return NewCompiler(sqldb.SQLite).Compile(node)
+}
+
+func errUnsupportedDialect(dialect sqldb.Dialect) error {
+	return fmt.Errorf("search does not support sql dialect %q", dialect)
+}
+
+type compiler struct {
+	dialect      sqldb.Dialect
+	now          func() time.Time
+	promotedKeys []PromotedKey
+	sql          strings.Builder
+	params       []interface{}
+}
+
+// compile writes the SQL for a node. parent is the logical operator of the enclosing expression,
+// used to decide if parentheses are required.
+func (c *compiler) compile(node Node, parent LogicalOp) error {
+	switch n := node.(type) {
+	case BinaryExpr:
+		wrap := parent != "" && parent != n.Op
+		if wrap {
+			c.sql.WriteString("(")
+		}
+		if err := c.compile(n.Left, n.Op); err != nil {
+			return err
+		}
+		c.sql.WriteString(" " + string(n.Op) + " ")
+		if err := c.compile(n.Right, n.Op); err != nil {
+			return err
+		}
+		if wrap {
+			c.sql.WriteString(")")
+		}
+		return nil
+	case NotExpr:
+		c.sql.WriteString("NOT (")
+		if err := c.compile(n.Expr, ""); err != nil {
+			return err
+		}
+		c.sql.WriteString(")")
+		return nil
+	case Comparison:
+		return c.comparison(n)
+	case Match:
+		return c.match(n)
+	case Visit:
+		return c.visit(n)
+	case In:
+		return c.in(n)
+	case IsNull:
+		return c.isNull(n)
+	}
+
+	return fmt.Errorf("unexpected node %T", node)
+}
+
+func (c *compiler) comparison(n Comparison) error {
+	if n.Field.Name == urlField {
+		return c.urlComparison(n)
+	}
+
+	f, err := c.lookup(n.Field)
+	if err != nil {
+		return err
+	}
+
+	f, err = c.typed(f, n.Field, n.Value)
+	if err != nil {
+		return err
+	}
+
+	if (n.Op == OpLike || n.Op == OpContains) && f.typ != typeText {
+		return errorf(n.Field.Pos, "field %q does not support %s", n.Field.Name, n.Op)
+	}
+
+	value, err := c.value(f, n.Field, n.Value)
+	if err != nil {
+		return err
+	}
+
+	c.sql.WriteString(f.column + " " + n.Op.SQL() + " ?")
+	if n.Op == OpContains {
+		c.sql.WriteString(LikeEscape(c.dialect))
+		value = "%" + EscapeLike(n.Value.Value) + "%"
+	}
+	c.params = append(c.params, value)
+	return nil
+}
+
+func (c *compiler) in(n In) error {
+	if n.Field.Name == urlField {
+		return errorf(n.Field.Pos, "field %q does not support IN", n.Field.Name)
+	}
+
+	f, err := c.lookup(n.Field)
+	if err != nil {
+		return err
+	}
+
+	// all values must have the same type, so meta fields are typed by the first one
+	if len(n.Values) > 0 {
+		if f, err = c.typed(f, n.Field, n.Values[0]); err != nil {
+			return err
+		}
+	}
+
+	placeholders := make([]string, 0, len(n.Values))
+	for _, lit := range n.Values {
+		value, err := c.value(f, n.Field, lit)
+		if err != nil {
+			return err
+		}
+		placeholders = append(placeholders, "?")
+		c.params = append(c.params, value)
+	}
+
+	c.sql.WriteString(f.column + " IN (" + strings.Join(placeholders, ", ") + ")")
+	return nil
+}
+
+func (c *compiler) isNull(n IsNull) error {
+	if n.Field.Name == urlField {
+		return errorf(n.Field.Pos, "field %q does not support IS NULL", n.Field.Name)
+	}
+
+	f, err := c.lookup(n.Field)
+	if err != nil {
+		return err
+	}
+
+	if n.Not {
+		c.sql.WriteString(f.column + " IS NOT NULL")
+	} else {
+		c.sql.WriteString(f.column + " IS NULL")
+	}
+	return nil
+}
+
+// match compiles full-text matches, looking up texts indexed by repo.SessionSQL.AddTexts
+func (c *compiler) match(n Match) error {
+	if n.Field.Name != textField {
+		return errorf(n.Field.Pos, "field %q does not support full-text search, only %q does", n.Field.Name, textField)
+	}
+
+	switch c.dialect {
+	case sqldb.SQLite:
+		c.sql.WriteString("s.id IN (SELECT session_id FROM session_texts WHERE session_texts MATCH ?)")
+		c.params = append(c.params, `"`+strings.ReplaceAll(n.Value.Value, `"`, `""`)+`"`)
+	case sqldb.Postgres:
+		c.sql.WriteString("s.id IN (SELECT session_id FROM session_texts WHERE to_tsvector('simple', content) @@ phraseto_tsquery('simple', ?))")
+		c.params = append(c.params, n.Value.Value)
+	case sqldb.MySQL:
+		c.sql.WriteString("s.id IN (SELECT session_id FROM session_texts WHERE LOWER(content) LIKE ?" + LikeEscape(c.dialect) + ")")
+		c.params = append(c.params, "%"+EscapeLike(strings.ToLower(n.Value.Value))+"%")
+	default:
+		return errUnsupportedDialect(c.dialect)
+	}
+
+	return nil
+}
+
+// EscapeLike escapes LIKE wildcards with backslashes (see LikeEscape)
+func EscapeLike(in string) string {
+	return likeEscaper.Replace(in)
+}
+
+// LikeEscape returns the ESCAPE clause for patterns escaped by EscapeLike, as SQLite doesn't have a
+// default escape character and MySQL requires backslashes to be escaped within strings
+func LikeEscape(dialect sqldb.Dialect) string {
+	if dialect == sqldb.MySQL {
+		return ` ESCAPE '\\'`
+	}
+	return ` ESCAPE '\'`
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,406 @@
+package search_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToSQL(t *testing.T) {
+	tests := []struct {
+		in     string
+		out    string
+		params []interface{}
+		err    bool
+	}{
+		{
+			in:     "os.name = 'Mac' AND os.version >= '10.5'",
+			out:    "os.name = ? AND os.version >= ?",
+			params: []interface{}{"Mac", "10.5"},
+		},
+		{
+			in:     "browser.name = 'Firefox' OR browser.name = 'Chrome' OR device = 'Other'",
+			out:    "browser.name = ? OR browser.name = ? OR s.device = ?",
+			params: []interface{}{"Firefox", "Chrome", "Other"},
+		},
+		{
+			in:     "(meta.foo = 'bar' OR meta.x = 10) AND NOT client_id = 'abc'",
+			out:    "(CAST(json_extract(s.meta, '$.foo') AS TEXT) = ? OR CASE WHEN json_type(s.meta, '$.x') IN ('integer', 'real') THEN json_extract(s.meta, '$.x') END = ?) AND NOT (s.client_id = ?)",
+			params: []interface{}{"bar", int64(10), "abc"},
+		},
+		{
+			in:     "meta.foo = 'x'' OR 1=1 --'",
+			out:    "CAST(json_extract(s.meta, '$.foo') AS TEXT) = ?",
+			params: []interface{}{"x' OR 1=1 --"},
+		},
+		{
+			in:     `text:"checkout ""failed""" AND os.name = 'Mac'`,
+			out:    "s.id IN (SELECT session_id FROM session_texts WHERE session_texts MATCH ?) AND os.name = ?",
+			params: []interface{}{`"checkout ""failed"""`, "Mac"},
+		},
+		{
+			in:     "duration > 300 AND has_error = true OR events.count >= 10.5 AND pages.count < 2",
+			out:    "(s.duration > ? AND s.has_error = ?) OR (s.events_count >= ? AND s.pages_count < ?)",
+			params: []interface{}{int64(300), true, 10.5, int64(2)},
+		},
+		{
+			in:  "duration > '300'",
+			err: true,
+		},
+		{
+			in:  "has_error = 1",
+			err: true,
+		},
+		{
+			in:  "os.name = false",
+			err: true,
+		},
+		{
+			in:     "url = '/checkout' AND NOT url ~ 'https://example.com/products/%' OR os.name ~ 'Mac%'",
+			out:    "(s.id IN (SELECT v.session_id FROM session_visits v WHERE v.path = ?) AND NOT (s.id IN (SELECT v.session_id FROM session_visits v WHERE v.url LIKE ?))) OR os.name LIKE ?",
+			params: []interface{}{"/checkout", "https://example.com/products/%", "Mac%"},
+		},
+		{
+			in:     "visited '/products/%' then '/cart' then 'https://example.com/checkout'",
+			out:    "s.id IN (SELECT v1.session_id FROM session_visits v1 JOIN session_visits v2 ON v2.session_id = v1.session_id AND v2.visited_at > v1.visited_at JOIN session_visits v3 ON v3.session_id = v2.session_id AND v3.visited_at > v2.visited_at WHERE v1.path LIKE ? AND v2.path = ? AND v3.url = ?)",
+			params: []interface{}{"/products/%", "/cart", "https://example.com/checkout"},
+		},
+		{
+			in:  "url = 1",
+			err: true,
+		},
+		{
+			in:  "duration ~ 1",
+			err: true,
+		},
+		{
+			in:     "browser.name != 'Chrome' AND os.name <> 'Mac' AND browser.name IN ('Firefox', 'Safari') AND duration NOT IN (0, 1)",
+			out:    "browser.name <> ? AND os.name <> ? AND browser.name IN (?, ?) AND NOT (s.duration IN (?, ?))",
+			params: []interface{}{"Chrome", "Mac", "Firefox", "Safari", int64(0), int64(1)},
+		},
+		{
+			in:     "meta.plan LIKE 'pro%' AND meta.email NOT LIKE '%@example.com' AND last_url contains '50%_off'",
+			out:    `CAST(json_extract(s.meta, '$.plan') AS TEXT) LIKE ? AND NOT (CAST(json_extract(s.meta, '$.email') AS TEXT) LIKE ?) AND s.last_url LIKE ? ESCAPE '\'`,
+			params: []interface{}{"pro%", "%@example.com", `%50\%\_off%`},
+		},
+		{
+			in:     "url contains '/cart' OR url != 'https://example.com/'",
+			out:    `s.id IN (SELECT v.session_id FROM session_visits v WHERE v.path LIKE ? ESCAPE '\') OR s.id NOT IN (SELECT v.session_id FROM session_visits v WHERE v.url = ?)`,
+			params: []interface{}{"%/cart%", "https://example.com/"},
+		},
+		{
+			in:  "meta.foo IS NULL OR meta.bar IS NOT NULL AND EXISTS user.id",
+			out: "CAST(json_extract(s.meta, '$.foo') AS TEXT) IS NULL OR (CAST(json_extract(s.meta, '$.bar') AS TEXT) IS NOT NULL AND u.id IS NOT NULL)",
+		},
+		{
+			in:  "duration IN (1, 'a')",
+			err: true,
+		},
+		{
+			in:  "duration contains '1'",
+			err: true,
+		},
+		{
+			in:  "url IS NULL",
+			err: true,
+		},
+		{
+			in:  "url IN ('/cart')",
+			err: true,
+		},
+		{
+			in:  "url > '/cart'",
+			err: true,
+		},
+		{
+			in:  "value = '1'",
+			err: true,
+		},
+		{
+			in:  "os.name:'Mac'",
+			err: true,
+		},
+		{
+			in:  "meta. = '1'",
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			out, params, err := search.ToSQL(test.in)
+			require.Equal(t, test.err, err != nil, err)
+			require.Equal(t, test.out, out)
+			require.Equal(t, test.params, params)
+		})
+	}
+}
+
+func TestCompilerDialects(t *testing.T) {
+	in := "meta.foo = 'bar' AND (meta.a.b = 'c' OR user.id = '1')"
+	tests := []struct {
+		dialect sqldb.Dialect
+		out     string
+		err     bool
+	}{
+		{
+			dialect: sqldb.SQLite,
+			out:     "CAST(json_extract(s.meta, '$.foo') AS TEXT) = ? AND (CAST(json_extract(s.meta, '$.a.b') AS TEXT) = ? OR u.id = ?)",
+		},
+		{
+			dialect: sqldb.Postgres,
+			out:     "(s.meta #>> '{foo}') = $1 AND ((s.meta #>> '{a,b}') = $2 OR u.id = $3)",
+		},
+		{
+			dialect: sqldb.MySQL,
+			out:     "JSON_UNQUOTE(JSON_EXTRACT(s.meta, '$.foo')) = ? AND (JSON_UNQUOTE(JSON_EXTRACT(s.meta, '$.a.b')) = ? OR u.id = ?)",
+		},
+		{
+			dialect: sqldb.Dialect("oracle"),
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.dialect), func(t *testing.T) {
+			out, params, err := search.NewCompiler(test.dialect).ToSQL(in)
+			require.Equal(t, test.err, err != nil, err)
+			if test.err {
+				return
+			}
+
+			out, err = test.dialect.Rebind(out)
+			require.NoError(t, err)
+			require.Equal(t, test.out, out)
+			require.Equal(t, []interface{}{"bar", "c", "1"}, params)
+		})
+	}
+}
+
+func TestCompilerTypedMeta(t *testing.T) {
+	in := "meta.cart.total > 100 AND meta.trial = true"
+	tests := []struct {
+		dialect sqldb.Dialect
+		out     string
+	}{
+		{
+			dialect: sqldb.SQLite,
+			out: "CASE WHEN json_type(s.meta, '$.cart.total') IN ('integer', 'real') THEN json_extract(s.meta, '$.cart.total') END > ? AND " +
+				"CASE WHEN json_type(s.meta, '$.trial') IN ('true', 'false') THEN json_extract(s.meta, '$.trial') END = ?",
+		},
+		{
+			dialect: sqldb.Postgres,
+			out: "CASE WHEN jsonb_typeof(s.meta #> '{cart,total}') = 'number' THEN (s.meta #>> '{cart,total}')::numeric END > $1 AND " +
+				"CASE WHEN jsonb_typeof(s.meta #> '{trial}') = 'boolean' THEN (s.meta #>> '{trial}')::boolean END = $2",
+		},
+		{
+			dialect: sqldb.MySQL,
+			out: "CASE WHEN JSON_TYPE(JSON_EXTRACT(s.meta, '$.cart.total')) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') THEN CAST(JSON_UNQUOTE(JSON_EXTRACT(s.meta, '$.cart.total')) AS DECIMAL(65, 30)) END > ? AND " +
+				"CASE WHEN JSON_TYPE(JSON_EXTRACT(s.meta, '$.trial')) = 'BOOLEAN' THEN JSON_UNQUOTE(JSON_EXTRACT(s.meta, '$.trial')) = 'true' END = ?",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.dialect), func(t *testing.T) {
+			out, params, err := search.NewCompiler(test.dialect).ToSQL(in)
+			require.NoError(t, err)
+
+			out, err = test.dialect.Rebind(out)
+			require.NoError(t, err)
+			require.Equal(t, test.out, out)
+			require.Equal(t, []interface{}{int64(100), true}, params)
+		})
+	}
+
+	for _, in := range []string{"meta.total IN (1, 'a')", "meta.total LIKE 10", "meta.created > now-1h"} {
+		t.Run(in, func(t *testing.T) {
+			_, _, err := search.NewCompiler(sqldb.SQLite).ToSQL(in)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCompilerPromotedMeta(t *testing.T) {
+	promoted, err := search.ParsePromotedKeys([]string{"plan", "meta.cart.total:number"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		in     string
+		out    string
+		params []interface{}
+	}{
+		{
+			in:     "meta.plan = 'pro' AND meta.cart.total > 100",
+			out:    "s.meta__plan = ? AND s.meta_number__cart__total > ?",
+			params: []interface{}{"pro", int64(100)},
+		},
+		{
+			in:     "meta.plan IN ('free', 'pro') OR meta.plan IS NULL",
+			out:    "s.meta__plan IN (?, ?) OR s.meta__plan IS NULL",
+			params: []interface{}{"free", "pro"},
+		},
+		{
+			in:     "meta.plan = 1 AND meta.cart.total = '100'",
+			out:    "CASE WHEN json_type(s.meta, '$.plan') IN ('integer', 'real') THEN json_extract(s.meta, '$.plan') END = ? AND CAST(json_extract(s.meta, '$.cart.total') AS TEXT) = ?",
+			params: []interface{}{int64(1), "100"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			compiler := search.NewCompiler(sqldb.SQLite)
+			compiler.Promoted = promoted
+
+			out, params, err := compiler.ToSQL(test.in)
+			require.NoError(t, err)
+			require.Equal(t, test.out, out)
+			require.Equal(t, test.params, params)
+		})
+	}
+
+	t.Run("postgres", func(t *testing.T) {
+		compiler := search.NewCompiler(sqldb.Postgres)
+		compiler.Promoted = promoted
+
+		// expression indexes match the compiled expressions
+		out, _, err := compiler.ToSQL("meta.plan = 'pro' AND meta.cart.total > 100")
+		require.NoError(t, err)
+		expr, err := promoted[1].Expr(sqldb.Postgres, "s.meta")
+		require.NoError(t, err)
+		require.Equal(t, "(s.meta #>> '{plan}') = ? AND "+expr+" > ?", out)
+	})
+}
+
+func TestParsePromotedKeys(t *testing.T) {
+	tests := []struct {
+		in      []string
+		columns []string
+		err     bool
+	}{
+		{in: []string{"plan", " meta.cart.total:number ", "trial:bool", ""}, columns: []string{"meta__plan", "meta_number__cart__total", "meta_bool__trial"}},
+		{in: []string{"plan,plan:number"}, columns: []string{"meta__plan", "meta_number__plan"}},
+		{in: []string{"plan", "plan:text"}, err: true},
+		{in: []string{"plan:date"}, err: true},
+		{in: []string{"plan'"}, err: true},
+		{in: []string{"a.b", "a__b"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.in, ","), func(t *testing.T) {
+			out, err := search.ParsePromotedKeys(test.in)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			columns := []string{}
+			for _, k := range out {
+				columns = append(columns, k.Column())
+			}
+			require.Equal(t, test.columns, columns)
+		})
+	}
+}
+
+func TestCompilerTime(t *testing.T) {
+	loc := time.FixedZone("BRT", -3*60*60)
+	now := time.Date(2021, 3, 10, 15, 30, 0, 0, loc)
+	compiler := search.NewCompiler(sqldb.SQLite)
+	compiler.Now = func() time.Time { return now }
+
+	tests := []struct {
+		in     string
+		params []interface{}
+		err    bool
+	}{
+		{
+			in:     "updated_at > now-2h",
+			params: []interface{}{time.Date(2021, 3, 10, 13, 30, 0, 0, loc)},
+		},
+		{
+			in:     "updated_at in last 7d",
+			params: []interface{}{time.Date(2021, 3, 3, 15, 30, 0, 0, loc)},
+		},
+		{
+			in:     "updated_at >= yesterday AND updated_at < today+1d",
+			params: []interface{}{time.Date(2021, 3, 9, 0, 0, 0, 0, loc), time.Date(2021, 3, 11, 0, 0, 0, 0, loc)},
+		},
+		{
+			in:     "updated_at >= '2021-03-01' AND updated_at < '2021-03-01T10:00:00Z'",
+			params: []interface{}{time.Date(2021, 3, 1, 0, 0, 0, 0, loc), time.Date(2021, 3, 1, 7, 0, 0, 0, loc)},
+		},
+		{
+			in:  "updated_at > 'yesterday'",
+			err: true,
+		},
+		{
+			in:     "created_at >= today",
+			params: []interface{}{time.Date(2021, 3, 10, 0, 0, 0, 0, loc)},
+		},
+		{
+			in:  "os.name = now",
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			_, params, err := compiler.ToSQL(test.in)
+			require.Equal(t, test.err, err != nil, err)
+			require.Equal(t, len(test.params), len(params))
+			for i := range test.params {
+				require.True(t, test.params[i].(time.Time).Equal(params[i].(time.Time)), "%v != %v", test.params[i], params[i])
+				require.Equal(t, loc, params[i].(time.Time).Location())
+			}
+		})
+	}
+}
+
+func TestCompilerEventTime(t *testing.T) {
+	now := time.Date(2021, 3, 10, 15, 30, 0, 0, time.UTC)
+	compiler := search.NewCompiler(sqldb.SQLite)
+	compiler.Now = func() time.Time { return now }
+
+	tests := []struct {
+		in     string
+		sql    string
+		params []interface{}
+		err    bool
+	}{
+		{
+			in:     "started_at > now-1h",
+			sql:    "s.first_event_ts > ?",
+			params: []interface{}{int64(1615386600000)},
+		},
+		{
+			in:     "ended_at <= '2021-03-10 15:30:00'",
+			sql:    "s.last_event_ts <= ?",
+			params: []interface{}{int64(1615390200000)},
+		},
+		{
+			in:  "started_at IS NULL",
+			sql: "s.first_event_ts IS NULL",
+		},
+		{
+			in:  "ended_at > 10",
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			sql, params, err := compiler.ToSQL(test.in)
+			require.Equal(t, test.err, err != nil, err)
+			if test.err {
+				return
+			}
+			require.Equal(t, test.sql, sql)
+			require.Equal(t, test.params, params)
+		})
+	}
+}
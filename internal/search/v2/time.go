@@ -0,0 +1,93 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnits are the units accepted in durations, such as 30m, 2h or 7d
+var durationUnits = map[rune]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// timeAnchors are the words which can start relative time expressions
+var timeAnchors = map[string]bool{
+	"now":       true,
+	"today":     true,
+	"yesterday": true,
+}
+
+// timeLayouts are the accepted layouts for time strings. Strings without timezone are parsed
+// using the compiler location.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDuration parses durations such as -2h or 7d
+func parseDuration(in string) (time.Duration, bool) {
+	if len(in) < 2 {
+		return 0, false
+	}
+
+	unit, ok := durationUnits[rune(in[len(in)-1])]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(in[:len(in)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// resolveTime resolves a relative time expression, such as `now-2h` or `today`, in relation to now
+func resolveTime(expr string, now time.Time) (time.Time, bool) {
+	anchor, offset := expr, ""
+	if i := strings.IndexAny(expr, "+-"); i > 0 {
+		anchor, offset = expr[:i], expr[i:]
+	}
+
+	var t time.Time
+	switch anchor {
+	case "now":
+		t = now
+	case "today":
+		t = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	case "yesterday":
+		t = time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location())
+	default:
+		return t, false
+	}
+
+	if offset == "" {
+		return t, true
+	}
+
+	d, ok := parseDuration(offset)
+	if !ok {
+		return t, false
+	}
+	return t.Add(d), true
+}
+
+// parseTime parses a time string using one of timeLayouts
+func parseTime(in string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, in, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
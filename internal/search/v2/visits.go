@@ -0,0 +1,64 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+)
+
+// visitColumn returns the session_visits column to compare against: values starting with `/` are
+// compared against the URL path, anything else against the full URL
+func visitColumn(alias, value string) string {
+	if strings.HasPrefix(value, "/") {
+		return alias + ".path"
+	}
+	return alias + ".url"
+}
+
+// urlComparison compiles comparisons against visited URLs, such as `url = '/checkout'` or `url ~ '/products/%'`
+func (c *compiler) urlComparison(n Comparison) error {
+	if n.Value.Kind != LiteralString {
+		return errorf(n.Value.Pos, "field %q expects a string", n.Field.Name)
+	}
+
+	switch n.Op {
+	case OpEq, OpLike:
+		c.sql.WriteString("s.id IN (SELECT v.session_id FROM session_visits v WHERE " + visitColumn("v", n.Value.Value) + " " + n.Op.SQL() + " ?)")
+		c.params = append(c.params, n.Value.Value)
+	case OpNeq:
+		// sessions which never visited the URL, rather than sessions which visited any other URL
+		c.sql.WriteString("s.id NOT IN (SELECT v.session_id FROM session_visits v WHERE " + visitColumn("v", n.Value.Value) + " = ?)")
+		c.params = append(c.params, n.Value.Value)
+	case OpContains:
+		c.sql.WriteString("s.id IN (SELECT v.session_id FROM session_visits v WHERE " + visitColumn("v", n.Value.Value) + " LIKE ?" + LikeEscape(c.dialect) + ")")
+		c.params = append(c.params, "%"+EscapeLike(n.Value.Value)+"%")
+	default:
+		return errorf(n.Field.Pos, "field %q does not support %s", n.Field.Name, n.Op)
+	}
+	return nil
+}
+
+// visit compiles path queries: each step joins session_visits again, requiring it to happen after the previous one.
+// Steps containing `%` are compared with LIKE.
+func (c *compiler) visit(n Visit) error {
+	var from, where strings.Builder
+	for i, step := range n.Steps {
+		alias := "v" + strconv.Itoa(i+1)
+		if i == 0 {
+			from.WriteString("session_visits " + alias)
+		} else {
+			prev := "v" + strconv.Itoa(i)
+			from.WriteString(" JOIN session_visits " + alias + " ON " + alias + ".session_id = " + prev + ".session_id AND " + alias + ".visited_at > " + prev + ".visited_at")
+			where.WriteString(" AND ")
+		}
+
+		op := OpEq
+		if strings.Contains(step.Value, "%") {
+			op = OpLike
+		}
+		where.WriteString(visitColumn(alias, step.Value) + " " + op.SQL() + " ?")
+		c.params = append(c.params, step.Value)
+	}
+
+	c.sql.WriteString("s.id IN (SELECT v1.session_id FROM " + from.String() + " WHERE " + where.String() + ")")
+	return nil
+}
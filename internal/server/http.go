@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/brunoluiz/jornada/internal/search/v2"
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
@@ -20,12 +23,28 @@ type SessionRepository interface {
 	Save(ctx context.Context, in repo.Session) error
 	GetByID(ctx context.Context, id string) (repo.Session, error)
 	Get(ctx context.Context, opts ...repo.GetOpt) ([]repo.Session, error)
+	Count(ctx context.Context, opts ...repo.GetOpt) (uint64, error)
+	List(ctx context.Context, opts repo.ListOpts, filters ...repo.GetOpt) (repo.SessionPage, error)
+	AddTexts(ctx context.Context, sessionID string, texts ...string) error
+	AddMetrics(ctx context.Context, sessionID string, m rrweb.Metrics) error
+	AddVisits(ctx context.Context, sessionID string, visits ...rrweb.Visit) error
+	SaveSearch(ctx context.Context, in repo.SavedSearch) (repo.SavedSearch, error)
+	GetSavedSearches(ctx context.Context) ([]repo.SavedSearch, error)
+	GetSavedSearchByID(ctx context.Context, id string) (repo.SavedSearch, error)
+	DeleteSavedSearch(ctx context.Context, id string) error
+	GetCommonValues(ctx context.Context, limit, sessions int) (map[string][]repo.ValueCount, error)
+	GetUsers(ctx context.Context, query string, limit uint64) ([]repo.UserSummary, error)
+	GetUser(ctx context.Context, id string) (repo.UserSummary, error)
+	Alias(ctx context.Context, anonymousID string, user repo.User) (repo.User, error)
+	GetAttributesHistory(ctx context.Context, sessionID string) ([]repo.AttributeChange, error)
 }
 
 // EventRepository defines an events repository
 type EventRepository interface {
 	Add(ctx context.Context, id string, msgs ...[]byte) error
 	Get(ctx context.Context, id string, cb func(b []byte, pos, size uint64) error) error
+	GetRange(ctx context.Context, id string, rng repo.EventRange, cb func(b []byte, pos, size uint64) error) (string, error)
+	KeyframeAt(ctx context.Context, id string, ts int64) (repo.Keyframe, bool, error)
 }
 
 // Server defines an HTTP Server
@@ -44,6 +63,10 @@ type Config struct {
 	PublicURL      string
 	AllowedOrigins []string
 	Anonymise      bool
+	SQLDialect     sqldb.Dialect
+
+	// PromotedMetaKeys are the indexed meta keys, which searches look up through their indexes
+	PromotedMetaKeys []search.PromotedKey
 }
 
 // Run start serving requests through configurations done in *Server
@@ -133,6 +156,11 @@ func NewAdmin(
 	if err := registerAdminRoutes(s); err != nil {
 		return nil, err
 	}
+	if err := registerUserRoutes(s); err != nil {
+		return nil, err
+	}
+	registerSavedSearchRoutes(s)
+	registerSearchRoutes(s)
 
 	s.server = &http.Server{
 		Addr:         config.Addr,
@@ -155,6 +183,7 @@ func NewPublic(
 	s := New(log, sessions, events, config)
 
 	registerSessionRoutes(s)
+	registerIdentifyRoutes(s)
 
 	s.server = &http.Server{
 		Addr:         config.Addr,
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/go-chi/chi"
+)
+
+// savedSearchCount is a saved search with the number of sessions matching it
+type savedSearchCount struct {
+	repo.SavedSearch
+	Count uint64 `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+func registerSavedSearchRoutes(s *Server) {
+	// Used by the session list form, which saves the current query
+	s.router.Post("/saved-searches", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			s.Error(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		saved, err := s.saveSearch(r.Context(), repo.SavedSearch{Name: r.PostForm.Get("name"), Query: r.PostForm.Get("q")})
+		if err != nil {
+			s.Error(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, "/sessions?saved="+url.QueryEscape(saved.ID), http.StatusSeeOther)
+	})
+
+	s.router.Route("/api/v1/saved-searches", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			res, err := s.countSavedSearches(r.Context())
+			if err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&res); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			var req repo.SavedSearch
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				s.Error(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			saved, err := s.saveSearch(r.Context(), repo.SavedSearch{Name: req.Name, Query: req.Query})
+			if err != nil {
+				s.Error(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(&saved); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			saved, err := s.sessions.GetSavedSearchByID(r.Context(), chi.URLParam(r, "id"))
+			if err != nil {
+				s.Error(w, r, err, savedSearchErrorCode(err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&saved); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
+		r.Delete("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if err := s.sessions.DeleteSavedSearch(r.Context(), chi.URLParam(r, "id")); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		// Runs the saved search, returning the matching sessions
+		r.Get("/{id}/sessions", func(w http.ResponseWriter, r *http.Request) {
+			saved, err := s.sessions.GetSavedSearchByID(r.Context(), chi.URLParam(r, "id"))
+			if err != nil {
+				s.Error(w, r, err, savedSearchErrorCode(err))
+				return
+			}
+
+			filter, err := s.searchFilter(saved.Query)
+			if err != nil {
+				s.Error(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			res, err := s.sessions.List(r.Context(), listOpts(r), filter)
+			if err != nil {
+				s.Error(w, r, err, listErrorCode(err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&res); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+	})
+}
+
+// saveSearch validates the search query before saving it
+func (s *Server) saveSearch(ctx context.Context, in repo.SavedSearch) (repo.SavedSearch, error) {
+	if in.Name == "" {
+		return in, errors.New("saved search name is required")
+	}
+
+	if _, err := s.searchFilter(in.Query); err != nil {
+		return in, err
+	}
+
+	return s.sessions.SaveSearch(ctx, in)
+}
+
+// countSavedSearches returns all saved searches with their session counts. If a saved search query
+// is no longer valid, the error is returned alongside it instead of failing the whole list.
+func (s *Server) countSavedSearches(ctx context.Context) ([]savedSearchCount, error) {
+	saved, err := s.sessions.GetSavedSearches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]savedSearchCount, 0, len(saved))
+	for _, search := range saved {
+		res := savedSearchCount{SavedSearch: search}
+
+		filter, err := s.searchFilter(search.Query)
+		if err != nil {
+			res.Error = err.Error()
+			out = append(out, res)
+			continue
+		}
+
+		res.Count, err = s.sessions.Count(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, res)
+	}
+
+	return out, nil
+}
+
+func savedSearchErrorCode(err error) int {
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/brunoluiz/jornada/internal/search/v2"
+)
+
+const (
+	suggestValuesLimit    = 10
+	suggestValuesMaxLimit = 100
+	// suggestSessions is how many of the most recently updated sessions are counted for suggestions
+	suggestSessions = 1000
+)
+
+type suggestResponse struct {
+	Fields []string                     `json:"fields"`
+	Values map[string][]repo.ValueCount `json:"values"`
+}
+
+func registerSearchRoutes(s *Server) {
+	// Returns the fields starting with `prefix` and, for the ones which have them, their most common values
+	s.router.Get("/api/v1/search/suggest", func(w http.ResponseWriter, r *http.Request) {
+		prefix := r.URL.Query().Get("prefix")
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > suggestValuesMaxLimit {
+			limit = suggestValuesLimit
+		}
+
+		values, err := s.sessions.GetCommonValues(r.Context(), limit, suggestSessions)
+		if err != nil {
+			s.Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		res := suggestResponse{Fields: []string{}, Values: map[string][]repo.ValueCount{}}
+		for _, field := range search.Fields() {
+			if strings.HasPrefix(field, prefix) {
+				res.Fields = append(res.Fields, field)
+			}
+		}
+
+		metaFields := []string{}
+		for field, v := range values {
+			if !strings.HasPrefix(field, prefix) {
+				continue
+			}
+			if strings.HasPrefix(field, "meta.") {
+				metaFields = append(metaFields, field)
+			}
+			res.Values[field] = v
+		}
+		sort.Strings(metaFields)
+		res.Fields = append(res.Fields, metaFields...)
+
+		if err := json.NewEncoder(w).Encode(&res); err != nil {
+			s.Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -1,14 +1,23 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/brunoluiz/jornada/internal/repo"
-	"github.com/brunoluiz/jornada/internal/search/v1"
+	"github.com/brunoluiz/jornada/internal/rrweb"
+	"github.com/brunoluiz/jornada/internal/search/v2"
 	"github.com/go-chi/chi"
 	"github.com/ua-parser/uap-go/uaparser"
 )
@@ -19,17 +28,18 @@ var templates embed.FS
 const (
 	templatePathSessionList = "session_list.html"
 	templatePathSessionByID = "session_by_id.html"
-
-	sessionListLimit = 10
 )
 
 type sessionListParams struct {
-	Sessions []repo.Session
-	URL      string
-	Query    string
-	Error    error
-	PrevPage int
-	NextPage int
+	Page          repo.SessionPage
+	List          repo.ListOpts
+	SavedSearches []savedSearchCount
+	SavedSearch   repo.SavedSearch
+	URL           string
+	Query         string
+	Error         error
+	PrevURL       string
+	NextURL       string
 }
 
 func registerAdminRoutes(s *Server) error {
@@ -43,41 +53,63 @@ func registerAdminRoutes(s *Server) error {
 	})
 
 	s.router.Get("/sessions", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query().Get("q")
-		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		params := sessionListParams{
+			URL:   s.config.PublicURL,
+			Query: r.URL.Query().Get("q"),
+			List:  listOpts(r),
+		}
+
+		savedSearches, err := s.countSavedSearches(r.Context())
 		if err != nil {
-			page = 0
+			s.Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		params.SavedSearches = savedSearches
+
+		if id := r.URL.Query().Get("saved"); id != "" {
+			saved, err := s.sessions.GetSavedSearchByID(r.Context(), id)
+			if err != nil {
+				w.WriteHeader(savedSearchErrorCode(err))
+				params.Query = ""
+				params.Error = err
+				err = t.ExecuteTemplate(w, templatePathSessionList, params)
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			params.Query = saved.Query
+			params.SavedSearch = saved
 		}
-		opts := []repo.GetOpt{repo.WithPagination(uint64(page)*sessionListLimit, sessionListLimit)}
 
-		if query != "" {
-			q, params, err := search.ToSQL(query)
+		filters := []repo.GetOpt{}
+		if params.Query != "" {
+			filter, err := s.searchFilter(params.Query)
 			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				params.Error = err
+				err = t.ExecuteTemplate(w, templatePathSessionList, params)
 				s.Error(w, r, err, http.StatusInternalServerError)
 				return
 			}
-			opts = append(opts, repo.WithSearchFilter(q, params))
+			filters = append(filters, filter)
 		}
 
-		data, err := s.sessions.Get(r.Context(), opts...)
+		params.Page, err = s.sessions.List(r.Context(), params.List, filters...)
 		if err != nil {
-			err = t.ExecuteTemplate(w, templatePathSessionList, sessionListParams{Sessions: data, URL: s.config.PublicURL, Query: query, Error: err, NextPage: -1, PrevPage: -1})
+			w.WriteHeader(listErrorCode(err))
+			params.Error = err
+			err = t.ExecuteTemplate(w, templatePathSessionList, params)
 			s.Error(w, r, err, http.StatusInternalServerError)
 			return
 		}
 
-		nextPage := page + 1
-		if len(data) < sessionListLimit {
-			nextPage = -1
+		if params.Page.Prev != "" {
+			params.PrevURL = pageURL(r, params.Page.Prev)
+		}
+		if params.Page.Next != "" {
+			params.NextURL = pageURL(r, params.Page.Next)
 		}
 
-		err = t.ExecuteTemplate(w, templatePathSessionList, sessionListParams{
-			Sessions: data,
-			URL:      s.config.PublicURL,
-			Query:    query,
-			NextPage: nextPage,
-			PrevPage: page - 1,
-		})
+		err = t.ExecuteTemplate(w, templatePathSessionList, params)
 		if err != nil {
 			s.Error(w, r, err, http.StatusInternalServerError)
 			return
@@ -93,10 +125,17 @@ func registerAdminRoutes(s *Server) error {
 			return
 		}
 
+		history, err := s.sessions.GetAttributesHistory(r.Context(), id)
+		if err != nil {
+			s.Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
 		err = t.ExecuteTemplate(w, templatePathSessionByID, struct {
 			ID      string
 			Session repo.Session
-		}{ID: id, Session: rec})
+			History []historyMarker
+		}{ID: id, Session: rec, History: historyMarkers(rec, history)})
 		if err != nil {
 			s.Error(w, r, err, http.StatusInternalServerError)
 			return
@@ -104,6 +143,29 @@ func registerAdminRoutes(s *Server) error {
 	})
 
 	s.router.Route("/api/v1/sessions", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			filters := []repo.GetOpt{}
+			if q := r.URL.Query().Get("q"); q != "" {
+				filter, err := s.searchFilter(q)
+				if err != nil {
+					s.Error(w, r, err, http.StatusBadRequest)
+					return
+				}
+				filters = append(filters, filter)
+			}
+
+			page, err := s.sessions.List(r.Context(), listOpts(r), filters...)
+			if err != nil {
+				s.Error(w, r, err, listErrorCode(err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&page); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
 		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
 			id := chi.URLParam(r, "id")
 
@@ -119,29 +181,71 @@ func registerAdminRoutes(s *Server) error {
 			}
 		})
 
-		// TODO: this might be better off if delivered as a stream or if the player is configured to have request chunks instead of all
+		r.Get("/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+			res, err := s.sessions.GetAttributesHistory(r.Context(), chi.URLParam(r, "id"))
+			if err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&res); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
 		r.Get("/{id}/events", func(w http.ResponseWriter, r *http.Request) {
 			id := chi.URLParam(r, "id")
 
-			err := s.events.Get(r.Context(), id, func(b []byte, pos, size uint64) error {
-				if pos == 0 {
-					bshadow := make([]byte, len(b)+1)
-					bshadow[0] = '['
-					copy(bshadow[1:], b)
-					b = bshadow
-					b = append(b, ',', '\n')
-				} else if pos == (size - 1) {
-					b = append(b, ']')
-				} else {
-					b = append(b, ',', '\n')
+			rng, err := eventRange(r)
+			if err != nil {
+				s.Error(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			// replays can start at a time (rrweb timestamp), being streamed from the nearest keyframe before it
+			if at := r.URL.Query().Get("at"); at != "" {
+				ts, err := strconv.ParseInt(at, 10, 64)
+				if err != nil {
+					s.Error(w, r, err, http.StatusBadRequest)
+					return
+				}
+
+				kf, ok, err := s.events.KeyframeAt(r.Context(), id, ts)
+				if err != nil {
+					s.Error(w, r, err, http.StatusInternalServerError)
+					return
 				}
-				_, err := w.Write(b)
-				return err
+				if ok {
+					if kf.Seq > rng.FromSeq {
+						rng.FromSeq = kf.Seq
+					}
+					w.Header().Set("X-Keyframe-Timestamp", strconv.FormatInt(kf.Timestamp, 10))
+				}
+			}
+
+			// pages are buffered, so their next page cursor can be set in the headers
+			var buf bytes.Buffer
+			var out io.Writer = w
+			if rng.Limit > 0 {
+				out = &buf
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			next, err := writeEvents(out, func(cb func(b []byte, pos, size uint64) error) (string, error) {
+				return s.events.GetRange(r.Context(), id, rng, cb)
 			})
 			if err != nil {
-				s.Error(w, r, err, http.StatusInternalServerError)
+				s.Error(w, r, err, listErrorCode(err))
 				return
 			}
+
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+			if _, err := buf.WriteTo(w); err != nil {
+				s.log.Error(err)
+			}
 		})
 	})
 
@@ -217,6 +321,178 @@ func registerSessionRoutes(s *Server) {
 			return
 		}
 
+		// Indexing is best effort: events are already stored, so the request shouldn't fail
+		if err := s.indexEvents(r.Context(), id, jsons...); err != nil {
+			s.log.WithError(err).WithField("session_id", id).Warn("could not index session events")
+		}
+
 		w.WriteHeader(http.StatusOK)
 	})
 }
+
+// searchFilter compiles a search query into a repo.GetOpt
+func (s *Server) searchFilter(query string) (repo.GetOpt, error) {
+	compiler := search.NewCompiler(s.config.SQLDialect)
+	compiler.Promoted = s.config.PromotedMetaKeys
+
+	q, params, err := compiler.ToSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.WithSearchFilter(q, params), nil
+}
+
+// listOpts reads session listing options (sort, order, limit and cursor) from the request query
+func listOpts(r *http.Request) repo.ListOpts {
+	query := r.URL.Query()
+	limit, err := strconv.ParseUint(query.Get("limit"), 10, 64)
+	if err != nil {
+		limit = 0
+	}
+
+	return repo.ListOpts{
+		Sort:   repo.SortField(query.Get("sort")),
+		Order:  repo.SortOrder(query.Get("order")),
+		Limit:  limit,
+		Cursor: query.Get("cursor"),
+	}
+}
+
+// pageURL returns the request URL pointing to another page of results
+func pageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).String()
+}
+
+func listErrorCode(err error) int {
+	if errors.Is(err, repo.ErrInvalidSort) || errors.Is(err, repo.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// indexEvents extracts texts, visits and metrics from rrweb events, saving them with the session. Each
+// of them is saved independently, so events which can't be indexed in one don't skip the others.
+func (s *Server) indexEvents(ctx context.Context, id string, msgs ...[]byte) error {
+	events, err := rrweb.Parse(msgs...)
+	if err != nil {
+		return err
+	}
+
+	errs := []string{}
+	texts, err := rrweb.Texts(events...)
+	if err == nil {
+		err = s.sessions.AddTexts(ctx, id, texts...)
+	}
+	if err != nil {
+		errs = append(errs, "texts: "+err.Error())
+	}
+
+	visits, err := rrweb.Visits(events...)
+	if err == nil {
+		err = s.sessions.AddVisits(ctx, id, visits...)
+	}
+	if err != nil {
+		errs = append(errs, "visits: "+err.Error())
+	}
+
+	if err := s.sessions.AddMetrics(ctx, id, rrweb.Summarise(events...)); err != nil {
+		errs = append(errs, "metrics: "+err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not index %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// historyMarker is an attribute change placed in the session re-play timeline
+type historyMarker struct {
+	Attribute string
+	Old       string
+	New       string
+	Timestamp int64
+	OffsetMs  int64
+	Offset    string
+}
+
+// historyMarkers places attribute changes relative to when the session started. Changes made before
+// any event was recorded are placed at the start.
+func historyMarkers(session repo.Session, history []repo.AttributeChange) []historyMarker {
+	start := session.CreatedAt
+	if session.StartedAt != nil {
+		start = *session.StartedAt
+	}
+
+	value := func(v *string) string {
+		if v == nil {
+			return "(none)"
+		}
+		return *v
+	}
+
+	out := make([]historyMarker, 0, len(history))
+	for _, change := range history {
+		offset := change.ChangedAt.Sub(start)
+		if offset < 0 {
+			offset = 0
+		}
+		out = append(out, historyMarker{
+			Attribute: change.Attribute,
+			Old:       value(change.OldValue),
+			New:       value(change.NewValue),
+			Timestamp: start.Add(offset).UnixNano() / int64(time.Millisecond),
+			OffsetMs:  offset.Milliseconds(),
+			Offset:    fmt.Sprintf("%02d:%02d", int(offset.Minutes()), int(offset.Seconds())%60),
+		})
+	}
+	return out
+}
+
+// writeEvents writes the events returned by get as a JSON array. The array is only opened once there
+// are events, so errors returned before them aren't written after a partial response.
+func writeEvents(w io.Writer, get func(cb func(b []byte, pos, size uint64) error) (string, error)) (string, error) {
+	sep := []byte{'['}
+	next, err := get(func(b []byte, pos, size uint64) error {
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+		sep = []byte{',', '\n'}
+		_, err := w.Write(b)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if sep[0] == '[' {
+		_, err = w.Write([]byte("[]"))
+	} else {
+		_, err = w.Write([]byte{']'})
+	}
+	return next, err
+}
+
+// eventRange reads the range of events to be returned from the request query
+func eventRange(r *http.Request) (rng repo.EventRange, err error) {
+	query := r.URL.Query()
+	for param, dst := range map[string]*uint64{"from_seq": &rng.FromSeq, "to_seq": &rng.ToSeq, "limit": &rng.Limit} {
+		if v := query.Get(param); v != "" {
+			if *dst, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return rng, fmt.Errorf("invalid %s: %w", param, err)
+			}
+		}
+	}
+	for param, dst := range map[string]*int64{"from_ts": &rng.FromTs, "to_ts": &rng.ToTs} {
+		if v := query.Get(param); v != "" {
+			if *dst, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return rng, fmt.Errorf("invalid %s: %w", param, err)
+			}
+		}
+	}
+	rng.Cursor = query.Get("cursor")
+
+	return rng, nil
+}
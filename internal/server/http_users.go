@@ -0,0 +1,183 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/brunoluiz/jornada/internal/repo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	templatePathUserList = "user_list.html"
+	templatePathUserByID = "user_by_id.html"
+)
+
+// userTimeline is a user with their sessions, which are sorted by when they were created by default
+type userTimeline struct {
+	User     repo.UserSummary `json:"user"`
+	Sessions repo.SessionPage `json:"sessions"`
+}
+
+type userListParams struct {
+	Users []repo.UserSummary
+	Query string
+	Error error
+}
+
+type userByIDParams struct {
+	userTimeline
+	List    repo.ListOpts
+	Error   error
+	PrevURL string
+	NextURL string
+}
+
+func registerUserRoutes(s *Server) error {
+	t, err := template.ParseFS(templates, "templates/*")
+	if err != nil {
+		return err
+	}
+
+	s.router.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		params := userListParams{Query: r.URL.Query().Get("q")}
+
+		params.Users, params.Error = s.sessions.GetUsers(r.Context(), params.Query, usersLimit(r))
+		if params.Error != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		err := t.ExecuteTemplate(w, templatePathUserList, params)
+		s.Error(w, r, err, http.StatusInternalServerError)
+	})
+
+	s.router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		params := userByIDParams{List: timelineOpts(r)}
+
+		params.userTimeline, params.Error = s.userTimeline(r, params.List)
+		if params.Error != nil {
+			w.WriteHeader(userErrorCode(params.Error))
+		}
+
+		if params.Sessions.Prev != "" {
+			params.PrevURL = pageURL(r, params.Sessions.Prev)
+		}
+		if params.Sessions.Next != "" {
+			params.NextURL = pageURL(r, params.Sessions.Next)
+		}
+
+		err := t.ExecuteTemplate(w, templatePathUserByID, params)
+		s.Error(w, r, err, http.StatusInternalServerError)
+	})
+
+	s.router.Route("/api/v1/users", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			res, err := s.sessions.GetUsers(r.Context(), r.URL.Query().Get("q"), usersLimit(r))
+			if err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&res); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			res, err := s.userTimeline(r, timelineOpts(r))
+			if err != nil {
+				s.Error(w, r, err, userErrorCode(err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(&res); err != nil {
+				s.Error(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		})
+	})
+
+	return nil
+}
+
+// identifyRequest links an anonymous user ID to a known user (see repo.SessionSQL.Alias)
+type identifyRequest struct {
+	AnonymousID string    `json:"anonymousId"`
+	User        repo.User `json:"user"`
+}
+
+func registerIdentifyRoutes(s *Server) {
+	s.router.Post("/api/v1/identify", func(w http.ResponseWriter, r *http.Request) {
+		var req identifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.Error(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		// users aren't recorded in anonymised mode, so there is nothing to link
+		if s.config.Anonymise {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		user, err := s.sessions.Alias(r.Context(), req.AnonymousID, req.User)
+		if err != nil {
+			s.Error(w, r, err, userErrorCode(err))
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(&user); err != nil {
+			s.Error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// userTimeline returns the user from the request path, with a page of their sessions
+func (s *Server) userTimeline(r *http.Request, opts repo.ListOpts) (out userTimeline, err error) {
+	id := chi.URLParam(r, "id")
+
+	out.User, err = s.sessions.GetUser(r.Context(), id)
+	if err != nil {
+		return out, err
+	}
+
+	// the user ID is resolved by GetUser, which might have been requested through an alias
+	out.Sessions, err = s.sessions.List(r.Context(), opts, repo.WithUserID(out.User.ID))
+	return out, err
+}
+
+// timelineOpts reads session listing options, sorting sessions by when they were created by default
+func timelineOpts(r *http.Request) repo.ListOpts {
+	opts := listOpts(r)
+	if opts.Sort == "" {
+		opts.Sort = repo.SortCreatedAt
+	}
+	return opts
+}
+
+func usersLimit(r *http.Request) uint64 {
+	limit, err := strconv.ParseUint(r.URL.Query().Get("limit"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
+func userErrorCode(err error) int {
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, repo.ErrInvalidAlias) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, repo.ErrAliasConflict) {
+		return http.StatusConflict
+	}
+	return listErrorCode(err)
+}
@@ -1,6 +1,7 @@
 package badgerdb
 
 import (
+	"fmt"
 	"net/url"
 	"time"
 
@@ -21,6 +22,9 @@ func New(dsn string, logger badger.Logger) (*BadgerStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	if path.Scheme != "badger" {
+		return nil, fmt.Errorf("badger storage requires a badger:// dsn, got %q", path.Scheme)
+	}
 
 	db, err := badger.Open(
 		badger.DefaultOptions(path.Path).
@@ -0,0 +1,56 @@
+package filedb
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// DefaultSegmentSize is the size after which segment files are rotated
+const DefaultSegmentSize int64 = 8 * 1024 * 1024
+
+// FileStore defines a directory where files are stored, configured through a DSN such as
+// file:///var/lib/jornada/events?compress=zstd&segment_size=8388608
+type FileStore struct {
+	Path        string
+	Compress    bool
+	SegmentSize int64
+}
+
+// New returns a file store, creating its directory if it doesn't exist
+func New(dsn string) (*FileStore, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, fmt.Errorf("file storage requires a file:// dsn, got %q", u.Scheme)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("file storage requires a path")
+	}
+
+	store := &FileStore{Path: u.Path, SegmentSize: DefaultSegmentSize}
+
+	query := u.Query()
+	switch c := query.Get("compress"); c {
+	case "", "none":
+	case "zstd":
+		store.Compress = true
+	default:
+		return nil, fmt.Errorf("file storage compression %q not supported, expected zstd or none", c)
+	}
+
+	if v := query.Get("segment_size"); v != "" {
+		if store.SegmentSize, err = strconv.ParseInt(v, 10, 64); err != nil || store.SegmentSize <= 0 {
+			return nil, fmt.Errorf("invalid file storage segment_size %q", v)
+		}
+	}
+
+	if err := os.MkdirAll(store.Path, 0o755); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
@@ -0,0 +1,42 @@
+package filedb_test
+
+import (
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/storage/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		dsn         string
+		compress    bool
+		segmentSize int64
+		err         bool
+	}{
+		{dsn: "file://" + dir, segmentSize: filedb.DefaultSegmentSize},
+		{dsn: "file://" + dir + "/events?compress=zstd&segment_size=1024", compress: true, segmentSize: 1024},
+		{dsn: "file://" + dir + "?compress=none", segmentSize: filedb.DefaultSegmentSize},
+		{dsn: "file://" + dir + "?compress=gzip", err: true},
+		{dsn: "file://" + dir + "?segment_size=0", err: true},
+		{dsn: "badger://" + dir, err: true},
+		{dsn: "file://", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			store, err := filedb.New(tt.dsn)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.compress, store.Compress)
+			require.Equal(t, tt.segmentSize, store.SegmentSize)
+			require.DirExists(t, store.Path)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package s3db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Defaults used when the DSN doesn't set them
+const (
+	DefaultEndpoint   = "s3.amazonaws.com"
+	DefaultBufferPath = "/tmp/jornada.s3buffer"
+	DefaultIdle       = 30 * time.Minute
+
+	// MinIdle is the shortest idle time accepted, as idle sessions are checked every Idle/2
+	MinIdle = time.Second
+)
+
+// S3Store defines a bucket (and key prefix) in an S3 compatible storage, configured through a DSN such as
+// s3://bucket/prefix?endpoint=localhost:9000&insecure=true&region=us-east-1&buffer=/tmp/jornada.s3buffer&idle=30m.
+// Credentials are set in the DSN (s3://key:secret@bucket/prefix) or read from the environment
+// (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, MINIO_ACCESS_KEY and MINIO_SECRET_KEY, ~/.aws/credentials or IAM).
+// Writes are buffered in a local directory, being uploaded once they are idle.
+type S3Store struct {
+	Client     *minio.Client
+	Bucket     string
+	Prefix     string
+	BufferPath string
+	Idle       time.Duration
+}
+
+// New returns a store instance backed by an S3 bucket, which must exist
+func New(ctx context.Context, dsn string) (*S3Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("s3 storage requires a s3:// dsn, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 storage requires a bucket")
+	}
+
+	query := u.Query()
+	store := &S3Store{
+		Bucket:     u.Host,
+		Prefix:     strings.Trim(u.Path, "/"),
+		BufferPath: DefaultBufferPath,
+		Idle:       DefaultIdle,
+	}
+	if v := query.Get("buffer"); v != "" {
+		store.BufferPath = v
+	}
+	if v := query.Get("idle"); v != "" {
+		if store.Idle, err = time.ParseDuration(v); err != nil || store.Idle < MinIdle {
+			return nil, fmt.Errorf("invalid s3 storage idle %q", v)
+		}
+	}
+
+	endpoint := DefaultEndpoint
+	if v := query.Get("endpoint"); v != "" {
+		endpoint = v
+	}
+	insecure := false
+	if v := query.Get("insecure"); v != "" {
+		if insecure, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid s3 storage insecure %q", v)
+		}
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	if u.User != nil {
+		secret, _ := u.User.Password()
+		creds = credentials.NewStaticV4(u.User.Username(), secret, "")
+	}
+
+	store.Client, err = minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !insecure,
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := store.Client.BucketExists(ctx, store.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("s3 storage bucket %s: %w", store.Bucket, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("s3 storage bucket %s doesn't exist", store.Bucket)
+	}
+
+	return store, nil
+}
+
+// Key returns the key of an object, under the store prefix
+func (store *S3Store) Key(parts ...string) string {
+	if store.Prefix == "" {
+		return strings.Join(parts, "/")
+	}
+	return store.Prefix + "/" + strings.Join(parts, "/")
+}
+
+// Put uploads an object
+func (store *S3Store) Put(ctx context.Context, key string, b []byte, contentType string) error {
+	_, err := store.Client.PutObject(ctx, store.Bucket, store.Key(key), bytes.NewReader(b), int64(len(b)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// Get downloads an object
+func (store *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	obj, err := store.Client.GetObject(ctx, store.Bucket, store.Key(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return io.ReadAll(obj)
+}
+
+// List returns the keys of all objects under a prefix, relative to the store prefix
+func (store *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	for obj := range store.Client.ListObjects(ctx, store.Bucket, minio.ListObjectsOptions{
+		Prefix:    store.Key(prefix),
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, strings.TrimPrefix(obj.Key, store.Key("")))
+	}
+	return keys, nil
+}
+
+// Remove deletes an object
+func (store *S3Store) Remove(ctx context.Context, key string) error {
+	return store.Client.RemoveObject(ctx, store.Bucket, store.Key(key), minio.RemoveObjectOptions{})
+}
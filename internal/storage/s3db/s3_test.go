@@ -0,0 +1,29 @@
+package s3db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/storage/s3db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	// DSNs are validated before connecting, so invalid ones fail without a storage
+	tests := []string{
+		"file:///tmp/events",
+		"s3:///prefix",
+		"s3://bucket?idle=0",
+		"s3://bucket?idle=1ns",
+		"s3://bucket?idle=999ms",
+		"s3://bucket?idle=forever",
+		"s3://bucket?insecure=maybe",
+	}
+
+	for _, dsn := range tests {
+		t.Run(dsn, func(t *testing.T) {
+			_, err := s3db.New(context.Background(), dsn)
+			require.Error(t, err)
+		})
+	}
+}
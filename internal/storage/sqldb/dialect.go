@@ -0,0 +1,103 @@
+package sqldb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Dialect defines which SQL engine is behind a *sql.DB, as SQL and placeholders might differ between them
+type Dialect string
+
+// Available dialects, matching their DSN schemes
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+	MySQL    Dialect = "mysql"
+)
+
+// DialectFromScheme returns the dialect for a DSN scheme
+func DialectFromScheme(scheme string) (Dialect, error) {
+	switch d := Dialect(scheme); d {
+	case SQLite, Postgres, MySQL:
+		return d, nil
+	}
+
+	return "", fmt.Errorf("sql dialect %s not supported", scheme)
+}
+
+// Placeholder returns the placeholder format used by the dialect
+func (d Dialect) Placeholder() sq.PlaceholderFormat {
+	if d == Postgres {
+		return sq.Dollar
+	}
+	return sq.Question
+}
+
+// Rebind replaces `?` placeholders with the ones used by the dialect
+func (d Dialect) Rebind(sql string) (string, error) {
+	return d.Placeholder().ReplacePlaceholders(sql)
+}
+
+var numberedPlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// Bind adapts a query using numbered placeholders ($1, $2...) to the dialect. MySQL only supports
+// positional placeholders (?), while SQLite numbers $N placeholders by their order in the query
+// (regardless of N), so params are repeated or reordered to match positional placeholders on both.
+// Queries already using positional placeholders (such as the ones built by squirrel) are kept as they are.
+func (d Dialect) Bind(query string, params []interface{}) (string, []interface{}, error) {
+	if d == Postgres || !numberedPlaceholder.MatchString(query) {
+		return query, params, nil
+	}
+
+	var err error
+	out := make([]interface{}, 0, len(params))
+	query = numberedPlaceholder.ReplaceAllStringFunc(query, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholder[1:])
+		if n < 1 || n > len(params) {
+			err = fmt.Errorf("no param for placeholder %s", placeholder)
+			return placeholder
+		}
+		out = append(out, params[n-1])
+		return "?"
+	})
+
+	return query, out, err
+}
+
+// Upsert returns the clause which updates columns of an existing row when an INSERT conflicts with
+// it. MySQL doesn't support ON CONFLICT, using the primary (or unique) keys instead of key.
+func (d Dialect) Upsert(key string, columns ...string) string {
+	sets := make([]string, 0, len(columns))
+	if d == MySQL {
+		for _, column := range columns {
+			sets = append(sets, column+" = VALUES("+column+")")
+		}
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+
+	for _, column := range columns {
+		sets = append(sets, column+" = EXCLUDED."+column)
+	}
+	return "ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// UpsertNonEmpty is like Upsert, but empty (or NULL) values don't replace the values of the existing
+// row of table
+func (d Dialect) UpsertNonEmpty(table, key string, columns ...string) string {
+	sets := make([]string, 0, len(columns))
+	if d == MySQL {
+		for _, column := range columns {
+			sets = append(sets, column+" = COALESCE(NULLIF(VALUES("+column+"), ''), "+column+")")
+		}
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+
+	for _, column := range columns {
+		sets = append(sets, column+" = COALESCE(NULLIF(EXCLUDED."+column+", ''), "+table+"."+column+")")
+	}
+	return "ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
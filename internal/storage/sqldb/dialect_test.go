@@ -0,0 +1,94 @@
+package sqldb_test
+
+import (
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialectBind(t *testing.T) {
+	tests := []struct {
+		dialect sqldb.Dialect
+		in      string
+		out     string
+		params  []interface{}
+		err     bool
+	}{
+		{
+			dialect: sqldb.Postgres,
+			in:      "UPDATE t SET a = $2, b = COALESCE($1, b) WHERE c = $2",
+			out:     "UPDATE t SET a = $2, b = COALESCE($1, b) WHERE c = $2",
+			params:  []interface{}{1, 2},
+		},
+		{
+			dialect: sqldb.MySQL,
+			in:      "UPDATE t SET a = $2, b = COALESCE($1, b), j = '$.foo' WHERE c = $2",
+			out:     "UPDATE t SET a = ?, b = COALESCE(?, b), j = '$.foo' WHERE c = ?",
+			params:  []interface{}{2, 1, 2},
+		},
+		{
+			dialect: sqldb.MySQL,
+			in:      "DELETE FROM t WHERE id IN (?, ?)",
+			out:     "DELETE FROM t WHERE id IN (?, ?)",
+			params:  []interface{}{1, 2},
+		},
+		{
+			dialect: sqldb.SQLite,
+			in:      "UPDATE t SET a = $2 WHERE a = $1",
+			out:     "UPDATE t SET a = ? WHERE a = ?",
+			params:  []interface{}{2, 1},
+		},
+		{
+			dialect: sqldb.MySQL,
+			in:      "DELETE FROM t WHERE id = $3",
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.dialect)+" "+test.in, func(t *testing.T) {
+			out, params, err := test.dialect.Bind(test.in, []interface{}{1, 2})
+			require.Equal(t, test.err, err != nil, err)
+			if test.err {
+				return
+			}
+			require.Equal(t, test.out, out)
+			require.Equal(t, test.params, params)
+		})
+	}
+}
+
+func TestDialectUpsert(t *testing.T) {
+	tests := []struct {
+		dialect sqldb.Dialect
+		out     string
+	}{
+		{dialect: sqldb.SQLite, out: "ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email"},
+		{dialect: sqldb.Postgres, out: "ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email"},
+		{dialect: sqldb.MySQL, out: "ON DUPLICATE KEY UPDATE name = VALUES(name), email = VALUES(email)"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.dialect), func(t *testing.T) {
+			require.Equal(t, test.out, test.dialect.Upsert("id", "name", "email"))
+		})
+	}
+}
+
+func TestDialectUpsertNonEmpty(t *testing.T) {
+	tests := []struct {
+		dialect sqldb.Dialect
+		out     string
+	}{
+		{dialect: sqldb.SQLite, out: "ON CONFLICT (id) DO UPDATE SET name = COALESCE(NULLIF(EXCLUDED.name, ''), users.name), email = COALESCE(NULLIF(EXCLUDED.email, ''), users.email)"},
+		{dialect: sqldb.Postgres, out: "ON CONFLICT (id) DO UPDATE SET name = COALESCE(NULLIF(EXCLUDED.name, ''), users.name), email = COALESCE(NULLIF(EXCLUDED.email, ''), users.email)"},
+		{dialect: sqldb.MySQL, out: "ON DUPLICATE KEY UPDATE name = COALESCE(NULLIF(VALUES(name), ''), name), email = COALESCE(NULLIF(VALUES(email), ''), email)"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.dialect), func(t *testing.T) {
+			require.Equal(t, test.out, test.dialect.UpsertNonEmpty("users", "id", "name", "email"))
+		})
+	}
+}
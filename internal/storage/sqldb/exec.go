@@ -5,14 +5,15 @@ import (
 	"database/sql"
 )
 
-// Cmd define a SQL instruction to be executed by sqldb.Exec
+// Cmd define a SQL instruction to be executed by sqldb.Exec. Params are referenced with numbered
+// placeholders ($1, $2...), whatever the dialect is.
 type Cmd struct {
 	SQL    string
 	Params []interface{}
 }
 
 // Exec execute SQL instructions inside of a transaction block. Useful for INSERT/UPSERTs.
-func Exec(ctx context.Context, db *sql.DB, cmds ...Cmd) error {
+func Exec(ctx context.Context, db *DB, cmds ...Cmd) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -23,12 +24,25 @@ func Exec(ctx context.Context, db *sql.DB, cmds ...Cmd) error {
 		}
 	}()
 
+	if err := ExecTx(ctx, tx, db.Dialect, cmds...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ExecTx execute SQL instructions inside of an existing transaction, which is neither committed nor
+// rolled back. Useful when commands depend on queries made in the same transaction.
+func ExecTx(ctx context.Context, tx *sql.Tx, dialect Dialect, cmds ...Cmd) error {
 	for _, cmd := range cmds {
-		_, err := tx.ExecContext(ctx, cmd.SQL, cmd.Params...)
+		query, params, err := dialect.Bind(cmd.SQL, cmd.Params)
 		if err != nil {
 			return err
 		}
-	}
 
-	return tx.Commit()
+		if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
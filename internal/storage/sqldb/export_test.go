@@ -0,0 +1,4 @@
+package sqldb
+
+// MySQLConfig exports mysqlConfig for tests
+var MySQLConfig = mysqlConfig
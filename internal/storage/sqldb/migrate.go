@@ -0,0 +1,243 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrSchemaOutdated is returned by Migrator.Check when the database schema doesn't match the migrations
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+var migrationsTable = map[Dialect]string{
+	SQLite: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`,
+	Postgres: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`,
+	MySQL: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(191) NOT NULL,
+		applied_at DATETIME(6) NOT NULL
+	)`,
+}
+
+// migrationsTableExists counts schema_migrations tables, so applied migrations can be read without creating it
+var migrationsTableExists = map[Dialect]string{
+	SQLite:   "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+	Postgres: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'",
+	MySQL:    "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'",
+}
+
+type (
+	// Migration is a versioned schema change, with up and down statements for each dialect
+	Migration struct {
+		Version int64
+		Name    string
+		Up      map[Dialect][]string
+		Down    map[Dialect][]string
+	}
+
+	// MigrationStatus is a migration with when it was applied, if it was
+	MigrationStatus struct {
+		Migration
+		Applied   bool
+		AppliedAt time.Time
+	}
+
+	// Migrator applies and rolls back migrations, recording applied versions in schema_migrations.
+	// Each migration runs in a transaction, but MySQL commits DDL statements implicitly: a MySQL
+	// migration which fails partway leaves its previous statements applied, without recording its
+	// version, and has to be fixed by hand before it is applied again.
+	Migrator struct {
+		db         *DB
+		migrations []Migration
+	}
+)
+
+// NewMigrator returns a Migrator for a set of migrations, which are applied in version order
+func NewMigrator(db *DB, migrations []Migration) *Migrator {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Status returns all migrations and if they were applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.Version]
+		out = append(out, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: at})
+	}
+
+	return out, nil
+}
+
+// Pending returns migrations which weren't applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []Migration{}
+	for _, s := range status {
+		if !s.Applied {
+			out = append(out, s.Migration)
+		}
+	}
+
+	return out, nil
+}
+
+// Check returns ErrSchemaOutdated if there are pending migrations or if the database has migrations
+// unknown to this version (applied by a newer release). It doesn't change the database.
+func (m *Migrator) Check(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := map[int64]bool{}
+	pending := 0
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: migration %d is unknown to this version", ErrSchemaOutdated, version)
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations", ErrSchemaOutdated, pending)
+	}
+
+	return nil
+}
+
+// Up applies all pending migrations, returning which were applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	table, ok := migrationsTable[m.db.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("migrations are not supported for %s", m.db.Dialect)
+	}
+
+	if err := Exec(ctx, m.db, Cmd{SQL: table}); err != nil {
+		return nil, err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range pending {
+		stmts, ok := migration.Up[m.db.Dialect]
+		if !ok {
+			return pending[:i], fmt.Errorf("migration %d has no up statements for %s", migration.Version, m.db.Dialect)
+		}
+
+		cmds := commands(stmts, Cmd{
+			SQL:    "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+			Params: []interface{}{migration.Version, migration.Name, time.Now()},
+		})
+		if err := Exec(ctx, m.db, cmds...); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return pending, nil
+}
+
+// Down rolls back the last applied migrations, returning which were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []Migration{}
+	for i := len(status) - 1; i >= 0 && len(out) < steps; i-- {
+		if !status[i].Applied {
+			continue
+		}
+
+		migration := status[i].Migration
+		stmts, ok := migration.Down[m.db.Dialect]
+		if !ok {
+			return out, fmt.Errorf("migration %d has no down statements for %s", migration.Version, m.db.Dialect)
+		}
+
+		cmds := commands(stmts, Cmd{
+			SQL:    "DELETE FROM schema_migrations WHERE version = $1",
+			Params: []interface{}{migration.Version},
+		})
+		if err := Exec(ctx, m.db, cmds...); err != nil {
+			return out, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		out = append(out, migration)
+	}
+
+	return out, nil
+}
+
+// applied returns applied migration versions and when they were applied, which are none if
+// schema_migrations wasn't created yet
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	exists, ok := migrationsTableExists[m.db.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("migrations are not supported for %s", m.db.Dialect)
+	}
+
+	var tables int
+	if err := m.db.QueryRowContext(ctx, exists).Scan(&tables); err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		return map[int64]time.Time{}, nil
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		out[version] = at
+	}
+
+	return out, rows.Err()
+}
+
+func commands(stmts []string, extra ...Cmd) []Cmd {
+	cmds := make([]Cmd, 0, len(stmts)+len(extra))
+	for _, stmt := range stmts {
+		cmds = append(cmds, Cmd{SQL: stmt})
+	}
+	return append(cmds, extra...)
+}
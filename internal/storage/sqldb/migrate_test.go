@@ -0,0 +1,101 @@
+package sqldb_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqldb.New("sqlite://" + filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations := []sqldb.Migration{
+		{
+			Version: 2,
+			Name:    "add_b",
+			Up:      map[sqldb.Dialect][]string{sqldb.SQLite: {"ALTER TABLE a ADD COLUMN b TEXT"}},
+			Down:    map[sqldb.Dialect][]string{sqldb.SQLite: {"CREATE TABLE a_down (id TEXT)", "DROP TABLE a", "ALTER TABLE a_down RENAME TO a"}},
+		},
+		{
+			Version: 1,
+			Name:    "create_a",
+			Up:      map[sqldb.Dialect][]string{sqldb.SQLite: {"CREATE TABLE a (id TEXT)"}},
+			Down:    map[sqldb.Dialect][]string{sqldb.SQLite: {"DROP TABLE a"}},
+		},
+	}
+	migrator := sqldb.NewMigrator(db, migrations)
+
+	err = migrator.Check(ctx)
+	require.True(t, errors.Is(err, sqldb.ErrSchemaOutdated), err)
+
+	// checks don't create schema_migrations
+	var tables int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables))
+	require.Zero(t, tables)
+
+	pending, err := migrator.Pending(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"create_a", "add_b"}, names(pending))
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"create_a", "add_b"}, names(applied))
+	require.NoError(t, migrator.Check(ctx))
+
+	_, err = db.ExecContext(ctx, "INSERT INTO a (id, b) VALUES ('1', '2')")
+	require.NoError(t, err)
+
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 2)
+	require.True(t, status[0].Applied && status[1].Applied)
+	require.False(t, status[0].AppliedAt.IsZero())
+
+	// the database has migrations unknown to an older version
+	err = sqldb.NewMigrator(db, migrations[1:]).Check(ctx)
+	require.True(t, errors.Is(err, sqldb.ErrSchemaOutdated), err)
+
+	rolledBack, err := migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"add_b"}, names(rolledBack))
+
+	pending, err = migrator.Pending(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"add_b"}, names(pending))
+
+	rolledBack, err = migrator.Down(ctx, 5)
+	require.NoError(t, err)
+	require.Equal(t, []string{"create_a"}, names(rolledBack))
+
+	// failed migrations are not recorded
+	broken := append(migrations, sqldb.Migration{
+		Version: 3,
+		Name:    "broken",
+		Up:      map[sqldb.Dialect][]string{sqldb.SQLite: {"ALTER TABLE foo ADD COLUMN c TEXT"}},
+	})
+	applied, err = sqldb.NewMigrator(db, broken).Up(ctx)
+	require.Error(t, err)
+	require.Equal(t, []string{"create_a", "add_b"}, names(applied))
+
+	pending, err = sqldb.NewMigrator(db, broken).Pending(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"broken"}, names(pending))
+
+	_, err = sqldb.NewMigrator(db, []sqldb.Migration{{Version: 4, Name: "postgres_only", Up: map[sqldb.Dialect][]string{sqldb.Postgres: {"SELECT 1"}}}}).Up(ctx)
+	require.Error(t, err)
+}
+
+func names(migrations []sqldb.Migration) []string {
+	out := []string{}
+	for _, m := range migrations {
+		out = append(out, m.Name)
+	}
+	return out
+}
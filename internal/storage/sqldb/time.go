@@ -0,0 +1,46 @@
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// NullTime scans a nullable time. Unlike sql.NullTime, it accepts times returned as text, as SQLite does
+// for expressions over DATETIME columns (such as MIN or MAX), which lose the column type.
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements sql.Scanner
+func (t *NullTime) Scan(value interface{}) error {
+	t.Time, t.Valid = time.Time{}, false
+
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		t.Time, t.Valid = v, true
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into NullTime", value)
+	}
+
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot parse %q as time", s)
+}
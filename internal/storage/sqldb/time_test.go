@@ -0,0 +1,35 @@
+package sqldb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunoluiz/jornada/internal/storage/sqldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNullTimeScan(t *testing.T) {
+	at := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in    interface{}
+		out   time.Time
+		valid bool
+		err   bool
+	}{
+		{in: nil},
+		{in: at, out: at, valid: true},
+		{in: "2021-03-01 10:00:00+00:00", out: at, valid: true},
+		{in: []byte("2021-03-01 07:00:00-03:00"), out: at, valid: true},
+		{in: "2021-03-01 10:00:00", out: at, valid: true},
+		{in: "yesterday", err: true},
+		{in: 10, err: true},
+	}
+
+	for _, test := range tests {
+		var out sqldb.NullTime
+		err := out.Scan(test.in)
+		require.Equal(t, test.err, err != nil, err)
+		require.Equal(t, test.valid, out.Valid)
+		require.True(t, test.out.Equal(out.Time), "%v != %v", test.out, out.Time)
+	}
+}
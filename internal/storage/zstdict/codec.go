@@ -0,0 +1,155 @@
+// Package zstdict compresses small payloads with zstd, using trained dictionaries. Each payload
+// references the dictionary it was compressed with, so dictionaries can be replaced (re-trained)
+// without re-compressing older payloads.
+package zstdict
+
+/*
+#include <stddef.h>
+
+// zstd.h is bundled (and compiled) by github.com/DataDog/zstd, which doesn't wrap frame headers
+unsigned long long ZSTD_getFrameContentSize(const void* src, size_t srcSize);
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/DataDog/zstd"
+)
+
+// MaxPayloadSize limits the uncompressed size of payloads, which is read from stored data before being
+// allocated
+const MaxPayloadSize = 256 * 1024 * 1024
+
+var (
+	// ErrUnknownDict is returned when a payload was compressed with a dictionary which wasn't added
+	ErrUnknownDict = errors.New("unknown zstd dictionary")
+	// ErrPayloadTooLarge is returned when compressing payloads larger than MaxPayloadSize, as they
+	// couldn't be decompressed
+	ErrPayloadTooLarge = errors.New("zstd payload is too large")
+)
+
+// Codec compresses payloads with its current dictionary and decompresses payloads compressed with any
+// of its dictionaries. Payloads are prefixed by the dictionary ID (0 if there was no dictionary) and
+// their uncompressed size. It is safe for concurrent use.
+type Codec struct {
+	level   int
+	mu      sync.RWMutex
+	dicts   map[uint32]*zstd.BulkProcessor
+	current uint32
+}
+
+// NewCodec returns a codec without dictionaries, using the given zstd compression level
+func NewCodec(level int) *Codec {
+	return &Codec{level: level, dicts: map[uint32]*zstd.BulkProcessor{}}
+}
+
+// AddDict adds a trained dictionary, returning its ID. If current is set, it is used to compress
+// payloads from now on.
+func (c *Codec) AddDict(dict []byte, current bool) (uint32, error) {
+	id := ID(dict)
+	if id == 0 {
+		return 0, errors.New("zstd dictionary has no ID, it must be trained")
+	}
+
+	p, err := zstd.NewBulkProcessor(dict, c.level)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dicts[id] = p
+	if current {
+		c.current = id
+	}
+	return id, nil
+}
+
+// Current returns the ID of the dictionary used to compress payloads, being 0 if there is none
+func (c *Codec) Current() uint32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+// Compress compresses a payload with the current dictionary
+func (c *Codec) Compress(src []byte) ([]byte, error) {
+	if len(src) > MaxPayloadSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(src))
+	}
+
+	c.mu.RLock()
+	id, p := c.current, c.dicts[c.current]
+	c.mu.RUnlock()
+
+	header := make([]byte, 4+binary.MaxVarintLen64)
+	binary.BigEndian.PutUint32(header, id)
+	n := binary.PutUvarint(header[4:], uint64(len(src)))
+
+	var frame []byte
+	var err error
+	if p != nil {
+		frame, err = p.Compress(nil, src)
+	} else {
+		frame, err = zstd.CompressLevel(nil, src, c.level)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return append(header[:4+n], frame...), nil
+}
+
+// Decompress decompresses a payload returned by Compress
+func (c *Codec) Decompress(src []byte) ([]byte, error) {
+	id, size, frame, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := make([]byte, 0, size)
+	if size == 0 {
+		return dst, nil
+	}
+	if id == 0 {
+		return zstd.Decompress(dst, frame)
+	}
+
+	c.mu.RLock()
+	p, ok := c.dicts[id]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownDict, id)
+	}
+	return p.Decompress(dst, frame)
+}
+
+// DictID returns the ID of the dictionary a payload was compressed with
+func DictID(src []byte) (uint32, error) {
+	id, _, _, err := parse(src)
+	return id, err
+}
+
+func parse(src []byte) (id uint32, size uint64, frame []byte, err error) {
+	if len(src) < 5 {
+		return 0, 0, nil, errors.New("invalid zstd payload")
+	}
+
+	size, n := binary.Uvarint(src[4:])
+	if n <= 0 || size > MaxPayloadSize {
+		return 0, 0, nil, errors.New("invalid zstd payload size")
+	}
+
+	// frames record their content size as well, so corrupt sizes are caught before being allocated
+	frame = src[4+n:]
+	if size > 0 && (len(frame) == 0 || uint64(C.ZSTD_getFrameContentSize(unsafe.Pointer(&frame[0]), C.size_t(len(frame)))) != size) {
+		return 0, 0, nil, errors.New("invalid zstd payload size")
+	}
+
+	return binary.BigEndian.Uint32(src), size, frame, nil
+}
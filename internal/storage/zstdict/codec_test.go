@@ -0,0 +1,104 @@
+package zstdict_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/brunoluiz/jornada/internal/storage/zstdict"
+	"github.com/stretchr/testify/require"
+)
+
+func samples(n int) [][]byte {
+	out := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, []byte(fmt.Sprintf(`{"type":3,"data":{"source":%d,"positions":[{"x":%d,"y":%d,"id":%d,"timeOffset":0}]},"timestamp":%d}`, i%5, i*7%1280, i*3%720, i%40, 1615000000000+i*16)))
+	}
+	return out
+}
+
+func TestCodec(t *testing.T) {
+	dict, err := zstdict.Train(samples(2000), 4096)
+	require.NoError(t, err)
+	require.NotZero(t, zstdict.ID(dict))
+
+	plain := zstdict.NewCodec(3)
+	trained := zstdict.NewCodec(3)
+	id, err := trained.AddDict(dict, true)
+	require.NoError(t, err)
+	require.Equal(t, zstdict.ID(dict), id)
+	require.Equal(t, id, trained.Current())
+
+	large := bytes.Repeat([]byte(`{"type":2,"data":{"node":{"type":0,"childNodes":[]}}}`), 100000)
+	for _, msg := range [][]byte{samples(1)[0], {}, large} {
+		for name, codec := range map[string]*zstdict.Codec{"plain": plain, "trained": trained} {
+			t.Run(fmt.Sprintf("%s/%d", name, len(msg)), func(t *testing.T) {
+				b, err := codec.Compress(msg)
+				require.NoError(t, err)
+
+				dictID, err := zstdict.DictID(b)
+				require.NoError(t, err)
+				require.Equal(t, codec.Current(), dictID)
+
+				out, err := codec.Decompress(b)
+				require.NoError(t, err)
+				require.Equal(t, msg, out)
+			})
+		}
+	}
+
+	t.Run("dictionary reduces size", func(t *testing.T) {
+		msg := samples(3000)[2500]
+		withDict, err := trained.Compress(msg)
+		require.NoError(t, err)
+		withoutDict, err := plain.Compress(msg)
+		require.NoError(t, err)
+		require.Less(t, len(withDict), len(withoutDict))
+	})
+
+	t.Run("unknown dictionary", func(t *testing.T) {
+		b, err := trained.Compress(samples(1)[0])
+		require.NoError(t, err)
+
+		_, err = plain.Decompress(b)
+		require.True(t, errors.Is(err, zstdict.ErrUnknownDict), err)
+	})
+
+	t.Run("corrupt size", func(t *testing.T) {
+		msg := samples(1)[0]
+		b, err := trained.Compress(msg)
+		require.NoError(t, err)
+		_, n := binary.Uvarint(b[4:])
+		frame := b[4+n:]
+
+		for _, size := range []uint64{1 << 62, zstdict.MaxPayloadSize, uint64(len(msg)) + 1} {
+			header := make([]byte, 4+binary.MaxVarintLen64)
+			copy(header, b[:4])
+			header = header[:4+binary.PutUvarint(header[4:], size)]
+
+			_, err := trained.Decompress(append(header, frame...))
+			require.Error(t, err, size)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := plain.Compress(make([]byte, zstdict.MaxPayloadSize+1))
+		require.True(t, errors.Is(err, zstdict.ErrPayloadTooLarge), err)
+
+		b, err := plain.Compress(make([]byte, zstdict.MaxPayloadSize))
+		require.NoError(t, err)
+		out, err := plain.Decompress(b)
+		require.NoError(t, err)
+		require.Len(t, out, zstdict.MaxPayloadSize)
+	})
+}
+
+func TestTrain(t *testing.T) {
+	_, err := zstdict.Train(samples(5), 4096)
+	require.True(t, errors.Is(err, zstdict.ErrNotEnoughSamples), err)
+
+	_, err = zstdict.NewCodec(3).AddDict([]byte("raw content"), true)
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package zstdict
+
+/*
+#include <stddef.h>
+
+// zdict.h is bundled (and compiled) by github.com/DataDog/zstd, which doesn't wrap dictionary training
+size_t ZDICT_trainFromBuffer(void* dictBuffer, size_t dictBufferCapacity, const void* samplesBuffer, const size_t* samplesSizes, unsigned nbSamples);
+unsigned ZDICT_isError(size_t errorCode);
+const char* ZDICT_getErrorName(size_t errorCode);
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unsafe"
+
+	_ "github.com/DataDog/zstd" // links the zdict symbols declared above
+)
+
+// DefaultDictSize is the recommended dictionary size (see zdict.h)
+const DefaultDictSize = 110 * 1024
+
+// dictMagic starts dictionaries in the zstd format, being followed by their ID
+const dictMagic = 0xEC30A437
+
+// ErrNotEnoughSamples is returned by Train when there are too few samples to train a dictionary
+var ErrNotEnoughSamples = errors.New("not enough samples to train a dictionary")
+
+// Train trains a dictionary of up to size bytes from samples of the data it will compress. Samples
+// should be a few thousand small payloads, which in total are around 100 times the dictionary size.
+func Train(samples [][]byte, size int) ([]byte, error) {
+	total := 0
+	for _, s := range samples {
+		total += len(s)
+	}
+	if len(samples) < 10 || total == 0 || size <= 0 {
+		return nil, ErrNotEnoughSamples
+	}
+
+	buf := make([]byte, 0, total)
+	sizes := make([]C.size_t, 0, len(samples))
+	for _, s := range samples {
+		buf = append(buf, s...)
+		sizes = append(sizes, C.size_t(len(s)))
+	}
+
+	dict := make([]byte, size)
+	n := C.ZDICT_trainFromBuffer(
+		unsafe.Pointer(&dict[0]), C.size_t(size),
+		unsafe.Pointer(&buf[0]), &sizes[0], C.unsigned(len(sizes)),
+	)
+	if C.ZDICT_isError(n) != 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotEnoughSamples, C.GoString(C.ZDICT_getErrorName(n)))
+	}
+
+	return dict[:n], nil
+}
+
+// ID returns the ID of a dictionary, which is 0 for raw content dictionaries (not trained)
+func ID(dict []byte) uint32 {
+	if len(dict) < 8 || binary.LittleEndian.Uint32(dict) != dictMagic {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(dict[4:])
+}